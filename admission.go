@@ -0,0 +1,218 @@
+package runtime
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// AdmissionConfig limits the number of concurrent evaluations, see WithAdmissionControl.
+type AdmissionConfig struct {
+	// MaxConcurrent is the maximum number of evaluations holding a storage transaction at the same time.
+	MaxConcurrent int
+	// MaxQueue is the maximum number of evaluations waiting for a slot. Evaluations are rejected
+	// as soon as all slots are taken if it is 0.
+	MaxQueue int
+	// MaxWait is the maximum time an evaluation waits for a slot. If 0, it waits until its context is done.
+	MaxWait time.Duration
+	// Key returns the fairness key of an evaluation, e.g. its tenant. Slots are handed out to the waiting
+	// evaluations of each key in turn, so a busy key cannot starve the others. All evaluations share
+	// the same key if nil.
+	Key func(ctx context.Context) string
+}
+
+// AdmissionKeyFromMetadata returns an AdmissionConfig.Key reading the fairness key from a field of the
+// decision metadata, see WithDecisionMetadata.
+func AdmissionKeyFromMetadata(field string) func(ctx context.Context) string {
+	return func(ctx context.Context) string {
+		if v, ok := DecisionMetadataFromContext(ctx)[field]; ok {
+			return fmt.Sprint(v)
+		}
+
+		return ""
+	}
+}
+
+// OverloadReason is the reason an evaluation was rejected by admission control.
+type OverloadReason string
+
+const (
+	// OverloadQueueFull is reported when all slots are taken and the queue is full.
+	OverloadQueueFull OverloadReason = "queue_full"
+	// OverloadMaxWait is reported when an evaluation waited longer than AdmissionConfig.MaxWait.
+	OverloadMaxWait OverloadReason = "max_wait"
+)
+
+// OverloadError is returned when admission control rejects an evaluation.
+type OverloadError struct {
+	Reason OverloadReason
+	Key    string
+	Waited time.Duration
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("%s: %s, key: [%s], waited: %s", ErrOverloaded, e.Reason, e.Key, e.Waited)
+}
+
+func (e *OverloadError) Is(target error) bool {
+	return target == ErrOverloaded
+}
+
+type admissionWaiter struct {
+	key      string
+	ready    chan struct{}
+	admitted bool
+}
+
+// admission hands out evaluation slots, queueing waiters by key and serving keys round-robin.
+type admission struct {
+	cfg AdmissionConfig
+
+	mu       sync.Mutex
+	inFlight int
+	queued   int
+	// queues holds the waiters of each key, and keys the keys with waiters in the order they are served.
+	queues map[string]*list.List
+	keys   *list.List
+	keyPos map[string]*list.Element
+}
+
+func newAdmission(cfg AdmissionConfig) *admission {
+	return &admission{
+		cfg:    cfg,
+		queues: map[string]*list.List{},
+		keys:   list.New(),
+		keyPos: map[string]*list.Element{},
+	}
+}
+
+// admit waits for an evaluation slot and returns the function releasing it.
+func (r *Runtime) admit(ctx context.Context) (func(), error) {
+	if r.admission == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+
+	release, err := r.admission.acquire(ctx, start)
+	r.metrics.observeAdmission(time.Since(start), err)
+
+	return release, err
+}
+
+func (a *admission) acquire(ctx context.Context, start time.Time) (func(), error) {
+	key := ""
+	if a.cfg.Key != nil {
+		key = a.cfg.Key(ctx)
+	}
+
+	a.mu.Lock()
+
+	if a.inFlight < a.cfg.MaxConcurrent && a.queued == 0 {
+		a.inFlight++
+		a.mu.Unlock()
+
+		return a.release, nil
+	}
+
+	if a.queued >= a.cfg.MaxQueue {
+		a.mu.Unlock()
+
+		return nil, &OverloadError{Reason: OverloadQueueFull, Key: key}
+	}
+
+	w := &admissionWaiter{key: key, ready: make(chan struct{})}
+	elem := a.enqueue(w)
+
+	a.mu.Unlock()
+
+	var timeout <-chan time.Time
+
+	if a.cfg.MaxWait > 0 {
+		timer := time.NewTimer(a.cfg.MaxWait)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	var err error
+
+	select {
+	case <-w.ready:
+		return a.release, nil
+	case <-timeout:
+		err = &OverloadError{Reason: OverloadMaxWait, Key: key, Waited: time.Since(start)}
+	case <-ctx.Done():
+		err = fmt.Errorf("%w: %w", ErrCancelled, ctx.Err())
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// the slot may have been handed out while timing out.
+	if w.admitted {
+		return a.release, nil
+	}
+
+	a.dequeue(w, elem)
+
+	return nil, err
+}
+
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.inFlight--
+
+	for a.inFlight < a.cfg.MaxConcurrent && a.queued > 0 {
+		key := a.keys.Front().Value.(string)
+		queue := a.queues[key]
+		w := queue.Front().Value.(*admissionWaiter)
+
+		a.dequeue(w, queue.Front())
+
+		// the key goes to the back of the line if it has more waiters.
+		if elem, ok := a.keyPos[key]; ok {
+			a.keys.MoveToBack(elem)
+		}
+
+		w.admitted = true
+		a.inFlight++
+		close(w.ready)
+	}
+}
+
+func (a *admission) enqueue(w *admissionWaiter) *list.Element {
+	queue, ok := a.queues[w.key]
+	if !ok {
+		queue = list.New()
+		a.queues[w.key] = queue
+		a.keyPos[w.key] = a.keys.PushBack(w.key)
+	}
+
+	a.queued++
+
+	return queue.PushBack(w)
+}
+
+func (a *admission) dequeue(w *admissionWaiter, elem *list.Element) {
+	queue := a.queues[w.key]
+	queue.Remove(elem)
+	a.queued--
+
+	if queue.Len() == 0 {
+		delete(a.queues, w.key)
+		a.keys.Remove(a.keyPos[w.key])
+		delete(a.keyPos, w.key)
+	}
+}
+
+func (a *admission) stats() (inFlight, queued int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.inFlight, a.queued
+}
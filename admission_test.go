@@ -0,0 +1,173 @@
+package runtime_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	opatypes "github.com/open-policy-agent/opa/v1/types"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdmissionControl(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+	reg := prometheus.NewRegistry()
+
+	var (
+		mu       sync.Mutex
+		admitted []string
+	)
+
+	held := make(chan struct{})
+	unblock := make(chan struct{})
+
+	// test.admitted records the order in which evaluations are admitted, and blocks the "hold" evaluation.
+	admittedBuiltin := runtime.WithBuiltin1(&rego.Function{
+		Name: "test.admitted",
+		Decl: opatypes.NewFunction(opatypes.Args(opatypes.S), opatypes.B),
+	}, func(_ rego.BuiltinContext, id *ast.Term) (*ast.Term, error) {
+		mu.Lock()
+		admitted = append(admitted, string(id.Value.(ast.String)))
+		mu.Unlock()
+
+		if id.Value.Compare(ast.String("hold")) == 0 {
+			close(held)
+			<-unblock
+		}
+
+		return ast.BooleanTerm(true), nil
+	})
+
+	r, err := runtime.New(ctx, &runtime.Config{},
+		admittedBuiltin,
+		runtime.WithPrometheus(reg),
+		runtime.WithAdmissionControl(runtime.AdmissionConfig{
+			MaxConcurrent: 1,
+			MaxQueue:      5,
+			Key:           runtime.AdmissionKeyFromMetadata("tenant"),
+		}),
+	)
+	assert.NoError(err)
+
+	query := func(ctx context.Context, tenant, id string) error {
+		_, err := r.Query(ctx, "x = test.admitted(input.id)", map[string]any{"id": id}, false, false, false, types.ExplainOffV1,
+			runtime.WithDecisionMetadata(map[string]any{"tenant": tenant}))
+
+		return err
+	}
+
+	queueDepth := func() float64 {
+		families, err := reg.Gather()
+		assert.NoError(err)
+
+		for _, family := range families {
+			if family.GetName() == "runtime_admission_queue_depth" {
+				return family.GetMetric()[0].GetGauge().GetValue()
+			}
+		}
+
+		return -1
+	}
+
+	var wg sync.WaitGroup
+
+	enqueue := func(tenant, id string, depth float64) {
+		wg.Go(func() {
+			assert.NoError(query(ctx, tenant, id))
+		})
+
+		assert.Eventually(func() bool { return queueDepth() == depth }, time.Second, time.Millisecond)
+	}
+
+	// Act
+	wg.Go(func() {
+		assert.NoError(query(ctx, "a", "hold"))
+	})
+	<-held
+
+	enqueue("a", "a1", 1)
+	enqueue("a", "a2", 2)
+	enqueue("a", "a3", 3)
+	enqueue("b", "b1", 4)
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	cancelled := make(chan error)
+
+	go func() {
+		cancelled <- query(timeoutCtx, "c", "c1")
+	}()
+
+	assert.Eventually(func() bool { return queueDepth() == 5 }, time.Second, time.Millisecond)
+
+	overloadErr := query(ctx, "d", "d1")
+
+	cancelErr := <-cancelled
+
+	close(unblock)
+	wg.Wait()
+
+	// Assert
+	var overload *runtime.OverloadError
+	assert.ErrorIs(overloadErr, runtime.ErrOverloaded)
+	assert.True(errors.As(overloadErr, &overload))
+	assert.Equal(runtime.OverloadQueueFull, overload.Reason)
+	assert.Equal("d", overload.Key)
+
+	assert.ErrorIs(cancelErr, runtime.ErrCancelled)
+
+	// tenants are served in turn.
+	assert.Equal([]string{"hold", "a1", "b1", "a2", "a3"}, admitted)
+	assert.Zero(queueDepth())
+
+	assert.NoError(promtestutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP runtime_admission_rejected_total Number of evaluations rejected by admission control, by reason (queue_full, max_wait or cancelled).
+# TYPE runtime_admission_rejected_total counter
+runtime_admission_rejected_total{reason="cancelled"} 1
+runtime_admission_rejected_total{reason="queue_full"} 1
+`), "runtime_admission_rejected_total"))
+}
+
+func TestAdmissionMaxWait(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{}, runtime.WithAdmissionControl(runtime.AdmissionConfig{
+		MaxConcurrent: 1,
+		MaxQueue:      1,
+		MaxWait:       10 * time.Millisecond,
+	}))
+	assert.NoError(err)
+
+	// Act
+	var queryErr error
+
+	for range r.QueryIter(ctx, "x = [1, 2][_]", nil) {
+		// the iteration holds the only slot.
+		_, queryErr = r.Query(ctx, "x = 1", nil, false, false, false, types.ExplainOffV1)
+		break
+	}
+
+	_, err = r.Query(ctx, "x = 1", nil, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	var overload *runtime.OverloadError
+	assert.True(errors.As(queryErr, &overload))
+	assert.Equal(runtime.OverloadMaxWait, overload.Reason)
+	assert.GreaterOrEqual(overload.Waited, 10*time.Millisecond)
+
+	assert.NoError(err)
+}
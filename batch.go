@@ -0,0 +1,85 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/pkg/errors"
+)
+
+// BatchQuery is a single query evaluated as part of a QueryBatch call.
+type BatchQuery struct {
+	Query string
+	Input map[string]any
+}
+
+// BatchResult holds the outcome of a single BatchQuery. Either Result or Err is set.
+type BatchResult struct {
+	Result *Result
+	Err    error
+}
+
+// QueryBatch evaluates queries concurrently using a bounded pool of workers (see WithBatchWorkers).
+// All queries share a single read transaction, so every result is computed against the same
+// revision of policies and data. Each evaluation is admitted on its own, see WithAdmissionControl.
+// Results are returned in the same order as queries, and a failing query only affects its own BatchResult.
+// Options apply to each query individually, except WithDecisionID: every query gets its own decision ID.
+func (r *Runtime) QueryBatch(ctx context.Context, queries []BatchQuery, opts ...QueryOption) ([]BatchResult, error) {
+	qo := r.queryOptions(opts)
+
+	txn, err := r.newQueryTransaction(ctx, qo)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create new OPA store transaction")
+	}
+
+	defer r.storage.Abort(ctx, txn)
+
+	results := make([]BatchResult, len(queries))
+	work := make(chan int)
+
+	var wg sync.WaitGroup
+
+	for range min(r.batchWorkers, len(queries)) {
+		wg.Go(func() {
+			for i := range work {
+				results[i].Result, results[i].Err = r.batchQuery(ctx, txn, &queries[i], qo)
+			}
+		})
+	}
+
+	for i := range queries {
+		work <- i
+	}
+
+	close(work)
+	wg.Wait()
+
+	return results, nil
+}
+
+func (r *Runtime) batchQuery(ctx context.Context, txn storage.Transaction, q *BatchQuery, qo *queryOptions) (*Result, error) {
+	ctx, decisionID := r.newDecisionContext(ctx, uuid.New().String(), qo)
+
+	parsedQuery, err := r.ValidateQuery(q.Query)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate query")
+	}
+
+	release, err := r.admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	result, err := r.execQuery(ctx, txn, decisionID, parsedQuery, q.Input, metrics.New(), types.ExplainOffV1, false, false, false, qo)
+	if err != nil {
+		return nil, evalError(decisionID, q.Query, err)
+	}
+
+	return result, nil
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/open-policy-agent/opa/v1/topdown"
 	"github.com/open-policy-agent/opa/v1/types"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // BuildTargetType represents the type of build target.
@@ -135,6 +136,17 @@ type BuildParams struct {
 
 // Build builds a bundle using the Aserto OPA Runtime.
 func (r *Runtime) Build(params *BuildParams, paths []string) error {
+	ctx, span := r.startSpan(context.Background(), "runtime.Build",
+		attribute.String(attrTarget, params.Target.String()),
+		attribute.StringSlice(attrEntrypoints, params.Entrypoints),
+		attribute.StringSlice(attrPaths, paths),
+	)
+	defer span.End()
+
+	return spanError(span, r.build(ctx, params, paths))
+}
+
+func (r *Runtime) build(ctx context.Context, params *BuildParams, paths []string) error {
 	buf := bytes.NewBuffer(nil)
 
 	if err := r.generateAllFakeBuiltins(paths); err != nil {
@@ -190,7 +202,7 @@ func (r *Runtime) Build(params *BuildParams, paths []string) error {
 		compiler = compiler.WithBundleVerificationKeyID(params.PubKeyID)
 	}
 
-	if err := compiler.Build(context.Background()); err != nil {
+	if err := compiler.Build(ctx); err != nil {
 		return err
 	}
 
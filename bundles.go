@@ -12,7 +12,6 @@ import (
 	"github.com/open-policy-agent/opa/v1/ast"
 	"github.com/open-policy-agent/opa/v1/server/types"
 	"github.com/open-policy-agent/opa/v1/storage"
-	opaTopdown "github.com/open-policy-agent/opa/v1/topdown"
 	"github.com/pkg/errors"
 )
 
@@ -39,7 +38,7 @@ func (r *Runtime) GetBundles(ctx context.Context) ([]*PolicyItem, error) {
 
 	bundles, err := getBundles(ctx, r)
 	if err != nil {
-		if strings.Contains(err.Error(), opaTopdown.CancelErr) {
+		if errors.Is(err, ErrCancelled) {
 			return results, nil
 		}
 
@@ -59,7 +58,7 @@ func (r *Runtime) GetBundles(ctx context.Context) ([]*PolicyItem, error) {
 func getBundles(ctx context.Context, r *Runtime) ([]*Bundle, error) {
 	const queryStmt = "data.system.bundles[x]"
 
-	queryResults, err := r.Query(ctx, queryStmt, nil, false, false, false, types.ExplainOffV1)
+	queryResults, err := r.Query(ctx, queryStmt, nil, false, false, false, types.ExplainOffV1, internalQuery())
 	if err != nil {
 		return []*Bundle{}, errors.Wrapf(err, "query bundles")
 	}
@@ -104,7 +103,7 @@ func (r *Runtime) GetBundleByID(ctx context.Context, id string) (*Bundle, error)
 		}
 	}
 
-	return &Bundle{}, errors.Errorf("bundle for policy id not found [%s]", id)
+	return &Bundle{}, &NotFoundError{Kind: "bundle", ID: id}
 }
 
 func calcID(v string) string {
@@ -314,7 +313,7 @@ func (r *Runtime) GetModule(ctx context.Context, id string) (*Module, error) {
 	pid := decID(id)
 
 	if !policyExists(ctx, r, pid) {
-		return &Module{}, errors.Errorf("policy not found [%s]", pid)
+		return &Module{}, &NotFoundError{Kind: "policy", ID: pid}
 	}
 
 	module, err := getModule(ctx, r, pid)
@@ -2,13 +2,16 @@ package runtime
 
 import (
 	"context"
+	"time"
 
 	"github.com/open-policy-agent/opa/v1/ast"
 	"github.com/open-policy-agent/opa/v1/metrics"
 	"github.com/open-policy-agent/opa/v1/rego"
 	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
 	"github.com/open-policy-agent/opa/v1/topdown"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // CompileResult contains the results of a Compile execution.
@@ -16,6 +19,8 @@ type CompileResult struct {
 	Result      *any
 	Metrics     map[string]any
 	Explanation types.TraceV1
+	DecisionID  string
+	Metadata    map[string]any
 }
 
 func (r *Runtime) Compile(
@@ -26,60 +31,143 @@ func (r *Runtime) Compile(
 	disableInlining []string,
 	pretty, includeMetrics, includeInstrumentation bool,
 	explain types.ExplainModeV1,
+	opts ...QueryOption,
 ) (*CompileResult, error) {
 	m := metrics.New()
 	m.Timer(metrics.ServerHandler).Start()
 
+	timestamp := time.Now().UTC()
+	qo := r.queryOptions(opts)
+	ctx, decisionID := r.decisionContext(ctx, qo)
+
+	ctx, span := r.startSpan(ctx, "runtime.Compile", attribute.String(attrDecisionID, decisionID), attribute.String(attrQuery, qStr))
+	defer span.End()
+
+	m.Timer(metrics.RegoQueryParse).Start()
+
+	parsedQuery, err := r.ValidateQuery(qStr)
+	if err != nil {
+		return nil, spanError(span, errors.Wrap(err, "failed to validate query"))
+	}
+
+	m.Timer(metrics.RegoQueryParse).Stop()
+
+	release, err := r.admit(ctx)
+	if err != nil {
+		return nil, spanError(span, err)
+	}
+
+	defer release()
+
 	txn, err := r.storage.NewTransaction(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create new OPA store transaction")
+		return nil, spanError(span, errors.Wrap(err, "failed to create new OPA store transaction"))
 	}
 
 	defer r.storage.Abort(ctx, txn)
 
-	var buf *topdown.BufferTracer
-	if explain != types.ExplainOffV1 {
-		buf = topdown.NewBufferTracer()
+	r.setSpanRevisions(ctx, span, txn)
+
+	result, err := r.compile(ctx, txn, decisionID, parsedQuery, input, unknowns, disableInlining, m,
+		pretty, includeMetrics, includeInstrumentation, explain, qo)
+	r.metrics.observeQuery("compile", parsedQuery, time.Since(timestamp), err)
+
+	d := &decision{
+		txn:        txn,
+		decisionID: decisionID,
+		query:      qStr,
+		input:      input,
+		metrics:    m,
+		metadata:   DecisionMetadataFromContext(ctx),
+		err:        err,
+		timestamp:  timestamp,
 	}
 
-	m.Timer(metrics.RegoQueryParse).Start()
+	if result != nil {
+		d.results = result.Result
+	}
+
+	r.logDecision(ctx, d)
 
-	parsedQuery, err := r.ValidateQuery(qStr)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to validate query")
+		return nil, spanError(span, evalError(decisionID, qStr, err))
 	}
 
-	m.Timer(metrics.RegoQueryParse).Stop()
+	if pe, ok := (*result.Result).(types.PartialEvaluationResultV1); ok {
+		span.SetAttributes(attribute.Int(attrResultSize, len(pe.Queries)))
+	}
 
-	eval := rego.New(
+	return result, nil
+}
+
+func (r *Runtime) compile(
+	ctx context.Context,
+	txn storage.Transaction,
+	decisionID string,
+	parsedQuery ast.Body,
+	input map[string]any,
+	unknowns []string,
+	disableInlining []string,
+	m metrics.Metrics,
+	pretty, includeMetrics, includeInstrumentation bool,
+	explain types.ExplainModeV1,
+	qo *queryOptions,
+) (*CompileResult, error) {
+	if r.wasmEvaluation {
+		return nil, errors.Wrap(ErrWasmUnsupported, "partial evaluation")
+	}
+
+	if err := qo.limits.checkInput(decisionID, input); err != nil {
+		return nil, err
+	}
+
+	budget := newEvalBudget(ctx, qo.limits)
+	defer budget.release()
+
+	ctx = budget.ctx
+
+	var buf *topdown.BufferTracer
+	if explain != types.ExplainOffV1 {
+		buf = topdown.NewBufferTracer()
+	}
+
+	regoOpts := append(r.regoOptions(txn, parsedQuery, m),
 		rego.Compiler(r.GetPluginsManager().GetCompiler()),
-		rego.Store(r.storage),
-		rego.Transaction(txn),
-		rego.ParsedQuery(parsedQuery),
 		rego.Input(input),
-		rego.Unknowns(unknowns),
+		rego.Unknowns(r.compileUnknowns(unknowns)),
+		rego.NondeterministicBuiltins(r.partialEvalNondeterministic),
 		rego.DisableInlining(disableInlining),
 		rego.QueryTracer(buf),
 		rego.Instrument(includeInstrumentation),
-		rego.Metrics(m),
-		rego.Runtime(r.pluginsManager.Info),
-		rego.UnsafeBuiltins(unsafeBuiltinsMap),
 		rego.InterQueryBuiltinCache(r.InterQueryCache),
 	)
 
-	pq, err := eval.Partial(ctx)
-	if err != nil {
-		var astErr ast.Errors
-		if errors.As(err, &astErr) {
-			return nil, errors.Wrap(astErr, "ast error")
+	if r.printStatements {
+		regoOpts = append(regoOpts, rego.PrintHook(&printHook{output: PrintToLogger}))
+	}
+
+	for _, resolver := range r.pluginsManager.GetWasmResolvers() {
+		for _, entrypoint := range resolver.Entrypoints() {
+			regoOpts = append(regoOpts, rego.Resolver(entrypoint, resolver))
 		}
+	}
 
-		return nil, err
+	for _, tracer := range budget.tracers() {
+		regoOpts = append(regoOpts, rego.QueryTracer(tracer))
+	}
+
+	pq, err := rego.New(regoOpts...).Partial(ctx)
+	if budgetErr := budget.err(decisionID); budgetErr != nil {
+		err = budgetErr
+	}
+
+	if err != nil {
+		return nil, cancelError(compileError(err))
 	}
 
 	m.Timer(metrics.ServerHandler).Stop()
 
-	result := &CompileResult{}
+	result := &CompileResult{DecisionID: decisionID, Metadata: DecisionMetadataFromContext(ctx)}
 
 	if includeMetrics || includeInstrumentation {
 		result.Metrics = m.All()
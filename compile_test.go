@@ -0,0 +1,157 @@
+package runtime_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	opatypes "github.com/open-policy-agent/opa/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+// compileRuntime returns a runtime with a compiletest.allowed rule calling the custom builtin test.<name>,
+// and importing data.compiletest in queries.
+func compileRuntime(t *testing.T, name string, nondeterministic bool, opts ...runtime.Option) *runtime.Runtime {
+	t.Helper()
+
+	ctx := t.Context()
+
+	region := runtime.WithBuiltin1(&rego.Function{
+		Name:             "test." + name,
+		Decl:             opatypes.NewFunction(opatypes.Args(opatypes.S), opatypes.S),
+		Nondeterministic: nondeterministic,
+	}, func(_ rego.BuiltinContext, tenant *ast.Term) (*ast.Term, error) {
+		return ast.StringTerm("eu-" + string(tenant.Value.(ast.String))), nil
+	})
+
+	r, err := runtime.New(ctx, &runtime.Config{}, append(opts, region, runtime.WithImport("data.compiletest"))...)
+	require.NoError(t, err)
+
+	policy := fmt.Sprintf(`package compiletest
+
+import rego.v1
+
+allowed if input.resource.region == test.%s(input.tenant)
+`, name)
+
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "compiletest.rego", []byte(policy))
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func compileQueries(t *testing.T, r *runtime.Runtime) []string {
+	t.Helper()
+
+	// the query relies on the data.compiletest import.
+	result, err := r.Compile(t.Context(), "compiletest.allowed == true", map[string]any{"tenant": "acme"},
+		[]string{"input.resource"}, nil, false, false, false, types.ExplainOffV1)
+	require.NoError(t, err)
+
+	pe, ok := (*result.Result).(types.PartialEvaluationResultV1)
+	require.True(t, ok)
+
+	queries := make([]string, len(pe.Queries))
+	for i, query := range pe.Queries {
+		queries[i] = query.String()
+	}
+
+	return queries
+}
+
+func TestCompileCustomBuiltin(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	r := compileRuntime(t, "region_default", false)
+
+	// Act
+	queries := compileQueries(t, r)
+	result, err := r.Query(t.Context(), "x = compiletest.allowed",
+		map[string]any{"tenant": "acme", "resource": map[string]any{"region": "eu-acme"}},
+		false, false, false, types.ExplainOffV1)
+
+	// Assert
+	assert.Equal([]string{`input.resource.region = "eu-acme"`}, queries)
+	assert.NoError(err)
+	assert.Equal(true, result.Result[0].Bindings["x"])
+}
+
+func TestCompileBuiltinPartialEval(t *testing.T) {
+	tests := []struct {
+		name             string
+		nondeterministic bool
+		mode             runtime.PartialEval
+		expected         string
+	}{
+		{"region_nondeterministic", true, runtime.PartialEvalDefault, `input.resource.region = test.region_nondeterministic("acme")`},
+		{"region_safe", true, runtime.PartialEvalSafe, `input.resource.region = "eu-acme"`},
+		{"region_unknown", false, runtime.PartialEvalUnknown, `input.resource.region = test.region_unknown("acme")`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			assert := require.New(t)
+			dir := t.TempDir()
+			r := compileRuntime(t, tt.name, tt.nondeterministic,
+				runtime.WithBuiltinPartialEval("test."+tt.name, tt.mode),
+				runtime.WithRecorder(&runtime.FileRecorder{Dir: dir}, nil))
+
+			// Act
+			queries := compileQueries(t, r)
+			result, err := r.Query(t.Context(), "x = compiletest.allowed",
+				map[string]any{"tenant": "acme", "resource": map[string]any{"region": "eu-acme"}},
+				false, false, false, types.ExplainOffV1, runtime.WithRecording())
+			assert.NoError(err)
+
+			recording, err := runtime.ReadRecording(filepath.Join(dir, result.DecisionID+".json"))
+			assert.NoError(err)
+
+			// Assert
+			assert.Equal([]string{tt.expected}, queries)
+			assert.Equal(true, result.Result[0].Bindings["x"])
+
+			// the mode doesn't change whether calls are recorded.
+			_, recorded := recording.NDBuiltinCache["test."+tt.name]
+			assert.Equal(tt.nondeterministic, recorded)
+		})
+	}
+}
+
+func TestCompileBuiltinPartialEvalPerRuntime(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	unknown := compileRuntime(t, "region_shared", false, runtime.WithBuiltinPartialEval("test.region_shared", runtime.PartialEvalUnknown))
+	other := compileRuntime(t, "region_shared", false)
+	safe := compileRuntime(t, "region_shared_safe", true, runtime.WithBuiltinPartialEval("test.region_shared_safe", runtime.PartialEvalSafe))
+
+	// other nondeterministic builtins are still kept when a builtin is evaluated.
+	err := storage.Txn(ctx, safe.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return safe.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "now.rego", []byte(`package compiletest
+
+import rego.v1
+
+allowed if input.resource.expires > time.now_ns()
+`))
+	})
+	assert.NoError(err)
+
+	// Act
+	unknownQueries := compileQueries(t, unknown)
+	otherQueries := compileQueries(t, other)
+	safeQueries := compileQueries(t, safe)
+
+	// Assert
+	assert.Equal([]string{`input.resource.region = test.region_shared("acme")`}, unknownQueries)
+	assert.Equal([]string{`input.resource.region = "eu-acme"`}, otherQueries)
+	assert.ElementsMatch([]string{`input.resource.region = "eu-acme"`, `gt(input.resource.expires, time.now_ns())`}, safeQueries)
+}
@@ -20,6 +20,7 @@ type Config struct {
 	GracefulShutdownPeriodSeconds int                `json:"graceful_shutdown_period_seconds"`
 	MaxPluginWaitTimeSeconds      int                `json:"max_plugin_wait_time_seconds"`
 	Flags                         Flags              `json:"flags"`
+	Builtins                      BuiltinsConfig     `json:"builtins"`
 	Config                        OPAConfig          `json:"config"`
 }
 
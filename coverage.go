@@ -0,0 +1,133 @@
+package runtime
+
+import (
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/cover"
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/pkg/errors"
+)
+
+// CoverageReport describes which lines of the runtime's policies were evaluated by sampled queries.
+// Files are keyed by the policy IDs returned by ListPolicies.
+type CoverageReport struct {
+	cover.Report
+
+	// Queries is the number of queries coverage was collected from.
+	Queries uint64 `json:"queries"`
+}
+
+// coverage collects policy coverage from a sampled fraction of queries.
+type coverage struct {
+	sampleRate float64
+	queries    atomic.Uint64
+
+	// hits accumulates the lines covered by the recorded queries. It is only used while holding mu,
+	// as cover.Cover doesn't synchronize Report with the tracing of a query.
+	mu   sync.Mutex
+	hits *cover.Cover
+}
+
+func newCoverage(sampleRate float64) *coverage {
+	return &coverage{sampleRate: sampleRate, hits: cover.New()}
+}
+
+// tracer returns a coverage tracer for a single query if it is sampled, nil otherwise.
+// Its hits are merged into the accumulated coverage by record, once the query is evaluated.
+func (c *coverage) tracer() *cover.Cover {
+	if c == nil || rand.Float64() >= c.sampleRate { //nolint:gosec
+		return nil
+	}
+
+	return cover.New()
+}
+
+func (c *coverage) record(tracer *cover.Cover) {
+	if c == nil || tracer == nil {
+		return
+	}
+
+	report := tracer.Report(nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queries.Add(1)
+
+	for file, fr := range report.Files {
+		for _, covered := range fr.Covered {
+			for row := covered.Start.Row; row <= covered.End.Row; row++ {
+				loc := &ast.Location{File: file, Row: row}
+				c.hits.TraceEvent(topdown.Event{Op: topdown.EvalOp, Node: &ast.Expr{Location: loc}})
+			}
+		}
+	}
+}
+
+func (c *coverage) reset() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hits = cover.New()
+	c.queries.Store(0)
+}
+
+func (c *coverage) report(modules map[string]*ast.Module) cover.Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hits.Report(modules)
+}
+
+// CoverageReport returns the coverage collected since coverage was enabled, reset, or the policies last changed.
+func (r *Runtime) CoverageReport() (*CoverageReport, error) {
+	if r.coverage == nil {
+		return nil, errors.New("coverage is not enabled")
+	}
+
+	// hits are recorded by file location, which may differ from the policy ID.
+	ids, policies := policyFiles(r.pluginsManager.GetCompiler())
+
+	modules := make(map[string]*ast.Module, len(ids))
+	for file, id := range ids {
+		modules[file] = policies[id]
+	}
+
+	report := r.coverage.report(modules)
+
+	result := &CoverageReport{
+		Report: cover.Report{
+			Files: make(map[string]*cover.FileReport, len(modules)),
+		},
+		Queries: r.coverage.queries.Load(),
+	}
+
+	for file, fr := range report.Files {
+		id, ok := ids[file]
+		if !ok {
+			continue
+		}
+
+		result.Files[id] = fr
+		result.CoveredLines += fr.CoveredLines
+		result.NotCoveredLines += fr.NotCoveredLines
+	}
+
+	if total := result.CoveredLines + result.NotCoveredLines; total > 0 {
+		result.Coverage = 100.0 * float64(result.CoveredLines) / float64(total)
+	}
+
+	return result, nil
+}
+
+// ResetCoverage discards the coverage collected so far.
+func (r *Runtime) ResetCoverage() {
+	r.coverage.reset()
+}
@@ -0,0 +1,119 @@
+package runtime_test
+
+import (
+	"sync"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoverageReport(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSchemaBundle()},
+		},
+	}, runtime.WithCoverage(1))
+	assert.NoError(err)
+
+	policies, err := r.ListPolicies(ctx)
+	assert.NoError(err)
+	assert.Len(policies, 1)
+
+	// Act
+	_, err = r.Query(ctx, "data.schema.allowed", map[string]any{"user": "alice"}, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	report, err := r.CoverageReport()
+	assert.NoError(err)
+
+	r.ResetCoverage()
+
+	reset, err := r.CoverageReport()
+	assert.NoError(err)
+
+	// Assert
+	assert.Equal(uint64(1), report.Queries)
+
+	fr, ok := report.Files[policies[0].ID]
+	assert.True(ok)
+	assert.True(fr.IsCovered(8))
+	assert.True(fr.IsNotCovered(10))
+	assert.Greater(report.Coverage, 0.0)
+	assert.Less(report.Coverage, 100.0)
+
+	assert.Equal(uint64(0), reset.Queries)
+	assert.Equal(0.0, reset.Coverage)
+}
+
+func TestCoverageDisabled(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+
+	r, err := runtime.New(t.Context(), &runtime.Config{})
+	assert.NoError(err)
+
+	// Act
+	_, err = r.CoverageReport()
+
+	// Assert
+	assert.Error(err)
+}
+
+func TestCoverageReportConcurrent(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSchemaBundle()},
+		},
+	}, runtime.WithCoverage(1))
+	assert.NoError(err)
+
+	const queries = 50
+
+	var wg sync.WaitGroup
+
+	// Act
+	for range 4 {
+		wg.Go(func() {
+			for range queries {
+				_, err := r.Query(ctx, "data.schema.allowed", map[string]any{"user": "alice"}, false, false, false, types.ExplainOffV1)
+				assert.NoError(err)
+			}
+		})
+	}
+
+	done := make(chan struct{})
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+
+		_, err := r.CoverageReport()
+		assert.NoError(err)
+	}
+
+	report, err := r.CoverageReport()
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(uint64(4*queries), report.Queries)
+	assert.Greater(report.CoveredLines, 0)
+}
@@ -0,0 +1,197 @@
+package datafilter
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/pkg/errors"
+)
+
+// Eval returns true if record matches the expression. Field paths are looked up in nested maps and,
+// using indices, in slices.
+func (e *Expr) Eval(record map[string]any) (bool, error) {
+	switch e.Op {
+	case OpTrue:
+		return true, nil
+	case OpFalse:
+		return false, nil
+	case OpAnd, OpOr, OpNot:
+		return e.evalLogical(record)
+	}
+
+	field, found := lookup(record, e.Field)
+	if !found {
+		return false, nil
+	}
+
+	fieldValue, err := ast.InterfaceToValue(field)
+	if err != nil {
+		return false, errors.Wrapf(err, "invalid value of field [%s]", strings.Join(e.Field, "."))
+	}
+
+	switch e.Op {
+	case OpIn:
+		return e.evalIn(fieldValue)
+	case OpStartsWith, OpEndsWith, OpContains:
+		return e.evalString(fieldValue)
+	default:
+		return e.evalComparison(record, fieldValue)
+	}
+}
+
+// evalLogical evaluates OpAnd, OpOr and OpNot.
+func (e *Expr) evalLogical(record map[string]any) (bool, error) {
+	if e.Op == OpNot {
+		if len(e.Args) != 1 {
+			return false, errors.Errorf("%s expects one operand, got %d", e.Op, len(e.Args))
+		}
+
+		ok, err := e.Args[0].Eval(record)
+
+		return !ok, err
+	}
+
+	for _, arg := range e.Args {
+		ok, err := arg.Eval(record)
+		if err != nil {
+			return false, err
+		}
+
+		// and stops at the first false operand, and or at the first true one.
+		if ok == (e.Op == OpOr) {
+			return ok, nil
+		}
+	}
+
+	return e.Op == OpAnd, nil
+}
+
+// evalIn evaluates OpIn.
+func (e *Expr) evalIn(fieldValue ast.Value) (bool, error) {
+	elems, ok := e.Value.([]any)
+	if !ok {
+		return false, errors.Errorf("the operand of %s must be a list", OpIn)
+	}
+
+	for _, elem := range elems {
+		v, err := ast.InterfaceToValue(elem)
+		if err != nil {
+			return false, errors.Wrap(err, "invalid value")
+		}
+
+		if fieldValue.Compare(v) == 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// evalString evaluates OpStartsWith, OpEndsWith and OpContains.
+func (e *Expr) evalString(fieldValue ast.Value) (bool, error) {
+	pattern, ok := e.Value.(string)
+	if !ok {
+		return false, errors.Errorf("the pattern of %s must be a string", e.Op)
+	}
+
+	s, ok := fieldValue.(ast.String)
+	if !ok {
+		return false, nil
+	}
+
+	switch e.Op {
+	case OpStartsWith:
+		return strings.HasPrefix(string(s), pattern), nil
+	case OpEndsWith:
+		return strings.HasSuffix(string(s), pattern), nil
+	default:
+		return strings.Contains(string(s), pattern), nil
+	}
+}
+
+// evalComparison evaluates OpEq, OpNe, OpLt, OpLte, OpGt and OpGte.
+func (e *Expr) evalComparison(record map[string]any, fieldValue ast.Value) (bool, error) {
+	other := e.Value
+
+	if e.Other != nil {
+		var found bool
+		if other, found = lookup(record, e.Other); !found {
+			return false, nil
+		}
+	}
+
+	otherValue, err := ast.InterfaceToValue(other)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid value")
+	}
+
+	cmp := fieldValue.Compare(otherValue)
+
+	switch e.Op {
+	case OpEq:
+		return cmp == 0, nil
+	case OpNe:
+		return cmp != 0, nil
+	case OpLt:
+		return cmp < 0, nil
+	case OpLte:
+		return cmp <= 0, nil
+	case OpGt:
+		return cmp > 0, nil
+	case OpGte:
+		return cmp >= 0, nil
+	default:
+		return false, errors.Errorf("unknown operator [%s]", e.Op)
+	}
+}
+
+// Filter returns the records matching the expression.
+func Filter(records []map[string]any, e *Expr) ([]map[string]any, error) {
+	var err error
+
+	filtered := slices.DeleteFunc(slices.Clone(records), func(record map[string]any) bool {
+		if err != nil {
+			return false
+		}
+
+		var ok bool
+		ok, err = e.Eval(record)
+
+		return !ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filtered, nil
+}
+
+// lookup returns the value at path in record, and false if it does not exist.
+func lookup(record map[string]any, path []string) (any, bool) {
+	var value any = record
+
+	for _, key := range path {
+		switch v := value.(type) {
+		case map[string]any:
+			elem, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+
+			value = elem
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return value, true
+}
@@ -0,0 +1,315 @@
+package datafilter
+
+import (
+	"encoding/json"
+	"fmt"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/pkg/errors"
+)
+
+// Op is the operator of an Expr.
+type Op string
+
+const (
+	// OpTrue is always true, and OpFalse always false. They have no operands.
+	OpTrue  Op = "true"
+	OpFalse Op = "false"
+
+	// OpAnd, OpOr and OpNot combine the expressions of Args.
+	OpAnd Op = "and"
+	OpOr  Op = "or"
+	OpNot Op = "not"
+
+	// OpEq, OpNe, OpLt, OpLte, OpGt and OpGte compare Field with Value, or with Other if set.
+	// Values are ordered as in Rego, and a missing field makes the comparison false.
+	OpEq  Op = "eq"
+	OpNe  Op = "ne"
+	OpLt  Op = "lt"
+	OpLte Op = "lte"
+	OpGt  Op = "gt"
+	OpGte Op = "gte"
+
+	// OpIn is true if Field equals one of the values of Value, a list.
+	OpIn Op = "in"
+
+	// OpStartsWith, OpEndsWith and OpContains are true if Field is a string starting with, ending with
+	// or containing Value, a string.
+	OpStartsWith Op = "startswith"
+	OpEndsWith   Op = "endswith"
+	OpContains   Op = "contains"
+)
+
+// Expr is a node of a boolean filter expression tree. It is built from the result of a Compile call
+// by FromCompileResult, and can be serialized to JSON, e.g.:
+//
+//	{"op": "or", "args": [
+//	  {"op": "eq", "field": ["owner"], "value": "alice"},
+//	  {"op": "in", "field": ["kind"], "value": ["report", "invoice"]}
+//	]}
+type Expr struct {
+	Op Op `json:"op"`
+	// Args are the operands of OpAnd, OpOr and OpNot.
+	Args []*Expr `json:"args,omitempty"`
+	// Field is the path of the field tested by the other operators.
+	Field []string `json:"field,omitempty"`
+	// Value is a string, number, boolean or null, or a list of them for OpIn. Numbers are json.Number.
+	Value any `json:"value,omitempty"`
+	// Other is the path of the field Field is compared with, instead of Value.
+	Other []string `json:"other,omitempty"`
+}
+
+var comparisons = map[string]Op{
+	ast.Equality.Name:      OpEq,
+	ast.Equal.Name:         OpEq,
+	ast.NotEqual.Name:      OpNe,
+	ast.LessThan.Name:      OpLt,
+	ast.LessThanEq.Name:    OpLte,
+	ast.GreaterThan.Name:   OpGt,
+	ast.GreaterThanEq.Name: OpGte,
+	ast.StartsWith.Name:    OpStartsWith,
+	ast.EndsWith.Name:      OpEndsWith,
+	ast.Contains.Name:      OpContains,
+	ast.Member.Name:        OpIn,
+}
+
+// flippedOps are the operators of comparisons whose operands are swapped.
+var flippedOps = map[Op]Op{OpEq: OpEq, OpNe: OpNe, OpLt: OpGt, OpLte: OpGte, OpGt: OpLt, OpGte: OpLte}
+
+// fieldFunc returns the field path of a reference to an unknown in expr.
+type fieldFunc func(expr *ast.Expr, ref ast.Ref) ([]string, error)
+
+// FromCompileResult converts the result of a Compile call into an expression tree. Its queries are joined
+// with OpOr, and the expressions of each query with OpAnd. A result without queries, meaning the decision
+// is always undefined, is converted to OpFalse, and an empty query to OpTrue.
+// Fields are the paths of the references to unknowns relative to root, e.g. "input.resource.owner" is
+// ["owner"] if root is "input.resource". References outside root, support rules and expressions other than
+// comparisons, startswith, endswith, contains and membership in a collection of values return
+// an *UnsupportedError.
+func FromCompileResult(result *runtime.CompileResult, root string) (*Expr, error) {
+	rootRef, err := ast.ParseRef(root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid root reference [%s]", root)
+	}
+
+	return fromCompileResult(result, func(expr *ast.Expr, ref ast.Ref) ([]string, error) {
+		if !ref.HasPrefix(rootRef) || len(ref) == len(rootRef) {
+			return nil, &UnsupportedError{Expr: expr.String(), Reason: fmt.Sprintf("%s is not a field of %s", ref, rootRef)}
+		}
+
+		path := make([]string, 0, len(ref)-len(rootRef))
+
+		for _, term := range ref[len(rootRef):] {
+			switch v := term.Value.(type) {
+			case ast.String:
+				path = append(path, string(v))
+			case ast.Number:
+				path = append(path, v.String())
+			default:
+				return nil, &UnsupportedError{Expr: expr.String(), Reason: fmt.Sprintf("%s is not a field path", ref)}
+			}
+		}
+
+		return path, nil
+	})
+}
+
+func fromCompileResult(result *runtime.CompileResult, field fieldFunc) (*Expr, error) {
+	if result == nil || result.Result == nil {
+		return nil, errors.New("compile result is empty")
+	}
+
+	pe, ok := (*result.Result).(types.PartialEvaluationResultV1)
+	if !ok {
+		return nil, errors.Errorf("unexpected compile result type %T", *result.Result)
+	}
+
+	if len(pe.Support) > 0 {
+		return nil, &UnsupportedError{Expr: pe.Support[0].Package.String(), Reason: "support rules cannot be translated"}
+	}
+
+	c := &converter{field: field}
+
+	return c.queries(pe.Queries)
+}
+
+type converter struct {
+	field fieldFunc
+}
+
+func (c *converter) queries(queries []ast.Body) (*Expr, error) {
+	disjuncts := make([]*Expr, 0, len(queries))
+
+	for _, query := range queries {
+		if len(query) == 0 {
+			// an empty query is always true, regardless of the others.
+			return &Expr{Op: OpTrue}, nil
+		}
+
+		conjuncts := make([]*Expr, 0, len(query))
+
+		for _, expr := range query {
+			e, err := c.expr(expr)
+			if err != nil {
+				return nil, err
+			}
+
+			conjuncts = append(conjuncts, e)
+		}
+
+		disjuncts = append(disjuncts, join(OpAnd, conjuncts))
+	}
+
+	if len(disjuncts) == 0 {
+		return &Expr{Op: OpFalse}, nil
+	}
+
+	return join(OpOr, disjuncts), nil
+}
+
+// join combines exprs with op, unless there is only one.
+func join(op Op, exprs []*Expr) *Expr {
+	if len(exprs) == 1 {
+		return exprs[0]
+	}
+
+	return &Expr{Op: op, Args: exprs}
+}
+
+func (c *converter) expr(expr *ast.Expr) (*Expr, error) {
+	if len(expr.With) > 0 {
+		return nil, &UnsupportedError{Expr: expr.String(), Reason: "with modifiers cannot be translated"}
+	}
+
+	e, err := c.positive(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	if expr.Negated {
+		return &Expr{Op: OpNot, Args: []*Expr{e}}, nil
+	}
+
+	return e, nil
+}
+
+func (c *converter) positive(expr *ast.Expr) (*Expr, error) {
+	if term, ok := expr.Terms.(*ast.Term); ok {
+		// a reference on its own is true if the field is true.
+		field, err := c.ref(expr, term)
+		if err != nil {
+			return nil, err
+		}
+
+		return &Expr{Op: OpEq, Field: field, Value: true}, nil
+	}
+
+	if !expr.IsCall() || len(expr.Operands()) != 2 {
+		return nil, &UnsupportedError{Expr: expr.String(), Reason: "only calls with two operands can be translated"}
+	}
+
+	name := expr.Operator().String()
+
+	op, ok := comparisons[name]
+	if !ok {
+		return nil, &UnsupportedError{Expr: expr.String(), Reason: fmt.Sprintf("%s cannot be translated", name)}
+	}
+
+	left, right := expr.Operand(0), expr.Operand(1)
+
+	for _, operand := range []*ast.Term{left, right} {
+		if call, ok := operand.Value.(ast.Call); ok {
+			return nil, &UnsupportedError{Expr: expr.String(), Reason: fmt.Sprintf("%s cannot be translated", call[0])}
+		}
+	}
+
+	if _, ok := flippedOps[op]; ok && !isRef(left) {
+		left, right = right, left
+		op = flippedOps[op]
+	}
+
+	field, err := c.ref(expr, left)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Expr{Op: op, Field: field}
+
+	switch {
+	case op == OpIn:
+		e.Value, err = values(expr, right)
+	case isRef(right):
+		e.Other, err = c.ref(expr, right)
+	default:
+		e.Value, err = value(expr, right)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := e.Value.(string); !ok && (op == OpStartsWith || op == OpEndsWith || op == OpContains) {
+		return nil, &UnsupportedError{Expr: expr.String(), Reason: "the pattern must be a string"}
+	}
+
+	return e, nil
+}
+
+func isRef(term *ast.Term) bool {
+	_, ok := term.Value.(ast.Ref)
+	return ok
+}
+
+func (c *converter) ref(expr *ast.Expr, term *ast.Term) ([]string, error) {
+	ref, ok := term.Value.(ast.Ref)
+	if !ok || !ref.IsGround() {
+		return nil, &UnsupportedError{Expr: expr.String(), Reason: fmt.Sprintf("%s is not a reference to a field", term)}
+	}
+
+	return c.field(expr, ref)
+}
+
+// value converts a scalar term.
+func value(expr *ast.Expr, term *ast.Term) (any, error) {
+	switch v := term.Value.(type) {
+	case ast.String:
+		return string(v), nil
+	case ast.Boolean:
+		return bool(v), nil
+	case ast.Number:
+		return json.Number(v), nil
+	case ast.Null:
+		return nil, nil
+	default:
+		return nil, &UnsupportedError{Expr: expr.String(), Reason: fmt.Sprintf("%s is not a string, number, boolean or null", term)}
+	}
+}
+
+// values converts an array or set of scalar terms.
+func values(expr *ast.Expr, term *ast.Term) ([]any, error) {
+	var elems []*ast.Term
+
+	switch v := term.Value.(type) {
+	case *ast.Array:
+		v.Foreach(func(e *ast.Term) { elems = append(elems, e) })
+	case ast.Set:
+		elems = v.Slice()
+	default:
+		return nil, &UnsupportedError{Expr: expr.String(), Reason: "membership can only be tested in an array or set of values"}
+	}
+
+	result := make([]any, len(elems))
+
+	for i, elem := range elems {
+		v, err := value(expr, elem)
+		if err != nil {
+			return nil, err
+		}
+
+		result[i] = v
+	}
+
+	return result, nil
+}
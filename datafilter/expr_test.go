@@ -0,0 +1,146 @@
+package datafilter_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aserto-dev/runtime/datafilter"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+var records = []map[string]any{
+	{"id": 1, "owner": "alice", "public": false, "kind": "note", "name": "todo", "size": 1},
+	{"id": 2, "owner": "bob", "public": true, "kind": "note", "name": "shopping", "size": 1},
+	{"id": 3, "owner": "bob", "public": false, "kind": "report", "name": "q1_sales", "size": 10},
+	{"id": 4, "owner": "bob", "public": false, "kind": "report", "name": "q1_forecast", "size": 500},
+	{"id": 5, "owner": "bob", "public": false, "kind": "invoice", "name": "q2_acme", "size": 10},
+	{"id": 6, "owner": "carol", "kind": "report", "name": "q1%", "size": 10.5},
+}
+
+func ids(filtered []map[string]any) []int {
+	result := make([]int, len(filtered))
+	for i, record := range filtered {
+		result[i] = record["id"].(int)
+	}
+
+	return result
+}
+
+func expr(t *testing.T, user string) *datafilter.Expr {
+	t.Helper()
+
+	r := newRuntime(t)
+
+	result, err := r.Compile(t.Context(), "data.filters.allowed == true", map[string]any{"user": user},
+		[]string{"input.resource"}, nil, false, false, false, types.ExplainOffV1)
+	require.NoError(t, err)
+
+	e, err := datafilter.FromCompileResult(result, "input.resource")
+	require.NoError(t, err)
+
+	return e
+}
+
+func TestFromCompileResult(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+
+	// Act
+	alice := expr(t, "alice")
+	admin := expr(t, "admin")
+
+	// Assert
+	assert.Equal(datafilter.OpOr, alice.Op)
+	assert.Contains(alice.Args, &datafilter.Expr{Op: datafilter.OpEq, Field: []string{"owner"}, Value: "alice"})
+	assert.Contains(alice.Args, &datafilter.Expr{Op: datafilter.OpEq, Field: []string{"public"}, Value: true})
+	assert.Equal(&datafilter.Expr{Op: datafilter.OpTrue}, admin)
+}
+
+func TestFromCompileResultOutsideRoot(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	r := newRuntime(t)
+
+	result, err := r.Compile(t.Context(), "data.filters.allowed == true", nil,
+		[]string{"input"}, nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Act
+	_, err = datafilter.FromCompileResult(result, "input.resource")
+
+	// Assert
+	assert.ErrorIs(err, datafilter.ErrUnsupported)
+	assert.ErrorContains(err, "is not a field of input.resource")
+}
+
+func TestEval(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	alice := expr(t, "alice")
+
+	b, err := json.Marshal(alice)
+	assert.NoError(err)
+
+	var decoded datafilter.Expr
+
+	assert.NoError(json.Unmarshal(b, &decoded))
+
+	// Act
+	filtered, err := datafilter.Filter(records, alice)
+	assert.NoError(err)
+
+	decodedFiltered, err := datafilter.Filter(records, &decoded)
+	assert.NoError(err)
+
+	// Assert
+	// the same records as in TestSQLite, record 6 has no public field.
+	assert.Equal([]int{1, 2, 3}, ids(filtered))
+	assert.Equal([]int{1, 2, 3}, ids(decodedFiltered))
+}
+
+func TestEvalOperators(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	record := map[string]any{
+		"name": "q1_sales",
+		"size": 10,
+		"max":  20.5,
+		"tags": []any{"finance", "2024"},
+		"meta": map[string]any{"region": "eu"},
+	}
+
+	tests := []struct {
+		expr     string
+		expected bool
+	}{
+		{`{"op": "true"}`, true},
+		{`{"op": "false"}`, false},
+		{`{"op": "eq", "field": ["meta", "region"], "value": "eu"}`, true},
+		{`{"op": "eq", "field": ["tags", "1"], "value": "2024"}`, true},
+		{`{"op": "eq", "field": ["missing"], "value": null}`, false},
+		{`{"op": "ne", "field": ["missing"], "value": "eu"}`, false},
+		{`{"op": "lt", "field": ["size"], "other": ["max"]}`, true},
+		{`{"op": "gte", "field": ["size"], "value": 10}`, true},
+		{`{"op": "gt", "field": ["name"], "value": 100}`, true},
+		{`{"op": "in", "field": ["size"], "value": [1, 10]}`, true},
+		{`{"op": "startswith", "field": ["name"], "value": "q1_"}`, true},
+		{`{"op": "endswith", "field": ["size"], "value": "0"}`, false},
+		{`{"op": "not", "args": [{"op": "contains", "field": ["name"], "value": "sales"}]}`, false},
+		{`{"op": "and", "args": [{"op": "true"}, {"op": "false"}]}`, false},
+		{`{"op": "or", "args": [{"op": "false"}, {"op": "true"}]}`, true},
+	}
+
+	for _, tt := range tests {
+		var e datafilter.Expr
+
+		assert.NoError(json.Unmarshal([]byte(tt.expr), &e))
+
+		// Act
+		ok, err := e.Eval(record)
+
+		// Assert
+		assert.NoError(err, tt.expr)
+		assert.Equal(tt.expected, ok, tt.expr)
+	}
+}
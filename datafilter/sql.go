@@ -0,0 +1,258 @@
+// Package datafilter translates the partial evaluation results returned by Runtime.Compile
+// into filters that can be applied by data stores, such as SQL WHERE clauses.
+package datafilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/pkg/errors"
+)
+
+// ErrUnsupported is matched by *UnsupportedError.
+var ErrUnsupported = errors.New("unsupported expression")
+
+// UnsupportedError is returned when a partial evaluation result contains an expression that cannot be translated.
+type UnsupportedError struct {
+	Expr   string
+	Reason string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("%s [%s]: %s", ErrUnsupported, e.Expr, e.Reason)
+}
+
+func (e *UnsupportedError) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+// Dialect is the SQL dialect of a WHERE clause.
+type Dialect int
+
+const (
+	// Postgres uses numbered placeholders: $1, $2...
+	Postgres Dialect = iota
+	// SQLite uses ? placeholders.
+	SQLite
+)
+
+// Columns maps the unknown references of a partial evaluation (e.g. "input.resource.owner")
+// to the columns they are stored in (e.g. "resources.owner"). Columns are written as is.
+type Columns map[string]string
+
+// SQLFilter is a parameterized SQL WHERE clause.
+type SQLFilter struct {
+	Where string
+	Args  []any
+}
+
+const (
+	sqlTrue  = "1 = 1"
+	sqlFalse = "1 = 0"
+)
+
+var sqlOperators = map[Op]string{OpEq: "=", OpNe: "<>", OpLt: "<", OpLte: "<=", OpGt: ">", OpGte: ">="}
+
+// sqlNull are the clauses comparing a column with null, which is lower than any other value.
+var sqlNull = map[Op]string{OpEq: " IS NULL", OpNe: " IS NOT NULL", OpLte: " IS NULL", OpGt: " IS NOT NULL"}
+
+// SQL translates the result of a Compile call into a WHERE clause, from the expression tree returned by
+// FromCompileResult: its queries are joined with OR, and the expressions of each query with AND. A result
+// without queries, meaning the decision is always undefined, is translated to a clause matching no rows,
+// and an empty query to a clause matching all rows. A NULL column holds a null value.
+// Support rules and expressions other than comparisons, startswith, endswith, contains and membership
+// in a collection of values return an *UnsupportedError.
+func SQL(result *runtime.CompileResult, columns Columns, dialect Dialect) (*SQLFilter, error) {
+	e, err := fromCompileResult(result, func(expr *ast.Expr, ref ast.Ref) ([]string, error) {
+		column, ok := columns[ref.String()]
+		if !ok {
+			return nil, &UnsupportedError{Expr: expr.String(), Reason: fmt.Sprintf("no column is mapped to %s", ref)}
+		}
+
+		return []string{column}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	t := &sqlTranslator{dialect: dialect}
+
+	where, err := t.expr(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQLFilter{Where: where, Args: t.args}, nil
+}
+
+type sqlTranslator struct {
+	dialect Dialect
+	args    []any
+}
+
+func (t *sqlTranslator) expr(e *Expr) (string, error) {
+	column := strings.Join(e.Field, ".")
+
+	switch e.Op {
+	case OpTrue:
+		return sqlTrue, nil
+	case OpFalse:
+		return sqlFalse, nil
+	case OpAnd, OpOr, OpNot:
+		return t.logical(e)
+	case OpIn:
+		return t.in(e, column)
+	case OpStartsWith, OpEndsWith, OpContains:
+		return t.like(e, column)
+	}
+
+	op, ok := sqlOperators[e.Op]
+	if !ok {
+		return "", errors.Errorf("unknown operator [%s]", e.Op)
+	}
+
+	if e.Other != nil {
+		return column + " " + op + " " + strings.Join(e.Other, "."), nil
+	}
+
+	if e.Value == nil {
+		switch e.Op {
+		case OpLt:
+			return sqlFalse, nil
+		case OpGte:
+			return sqlTrue, nil
+		default:
+			return column + sqlNull[e.Op], nil
+		}
+	}
+
+	value, err := sqlValue(e.Value)
+	if err != nil {
+		return "", err
+	}
+
+	return column + " " + op + " " + t.arg(value), nil
+}
+
+// logical translates OpAnd, OpOr and OpNot.
+func (t *sqlTranslator) logical(e *Expr) (string, error) {
+	if e.Op == OpNot {
+		if len(e.Args) != 1 {
+			return "", errors.Errorf("%s expects one operand, got %d", e.Op, len(e.Args))
+		}
+
+		clause, err := t.expr(e.Args[0])
+
+		return "NOT (" + clause + ")", err
+	}
+
+	clauses := make([]string, len(e.Args))
+
+	for i, arg := range e.Args {
+		clause, err := t.expr(arg)
+		if err != nil {
+			return "", err
+		}
+
+		// the operands of OR, and the disjunctions within AND, are parenthesized.
+		if e.Op == OpOr || arg.Op == OpOr {
+			clause = "(" + clause + ")"
+		}
+
+		clauses[i] = clause
+	}
+
+	if e.Op == OpOr {
+		return strings.Join(clauses, " OR "), nil
+	}
+
+	return strings.Join(clauses, " AND "), nil
+}
+
+// like translates OpStartsWith, OpEndsWith and OpContains.
+func (t *sqlTranslator) like(e *Expr, column string) (string, error) {
+	s, ok := e.Value.(string)
+	if !ok {
+		return "", errors.Errorf("the pattern of %s must be a string", e.Op)
+	}
+
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+
+	switch e.Op {
+	case OpStartsWith:
+		pattern += "%"
+	case OpEndsWith:
+		pattern = "%" + pattern
+	default:
+		pattern = "%" + pattern + "%"
+	}
+
+	return column + " LIKE " + t.arg(pattern) + ` ESCAPE '\'`, nil
+}
+
+// in translates OpIn. Null values are tested with IS NULL.
+func (t *sqlTranslator) in(e *Expr, column string) (string, error) {
+	elems, ok := e.Value.([]any)
+	if !ok {
+		return "", errors.Errorf("the operand of %s must be a list", OpIn)
+	}
+
+	var (
+		params []string
+		null   bool
+	)
+
+	for _, elem := range elems {
+		if elem == nil {
+			null = true
+			continue
+		}
+
+		value, err := sqlValue(elem)
+		if err != nil {
+			return "", err
+		}
+
+		params = append(params, t.arg(value))
+	}
+
+	switch {
+	case len(params) == 0 && null:
+		return column + " IS NULL", nil
+	case len(params) == 0:
+		return sqlFalse, nil
+	case null:
+		return "(" + column + " IN (" + strings.Join(params, ", ") + ") OR " + column + " IS NULL)", nil
+	default:
+		return column + " IN (" + strings.Join(params, ", ") + ")", nil
+	}
+}
+
+// sqlValue converts a value of an expression to a query parameter.
+func sqlValue(value any) (any, error) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return value, nil
+	}
+
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+
+	return n.Float64()
+}
+
+// arg adds a query parameter and returns its placeholder.
+func (t *sqlTranslator) arg(value any) string {
+	t.args = append(t.args, value)
+
+	if t.dialect == Postgres {
+		return "$" + strconv.Itoa(len(t.args))
+	}
+
+	return "?"
+}
@@ -0,0 +1,245 @@
+package datafilter_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/datafilter"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const filtersPolicy = `package filters
+
+import rego.v1
+
+allowed if input.resource.owner == input.user
+
+allowed if input.resource.public
+
+allowed if input.user == "admin"
+
+allowed if {
+	input.resource.kind in ["report", "invoice"]
+	startswith(input.resource.name, "q1_")
+	input.resource.size < 100
+}
+
+large if count(input.resource.tags) > 2
+`
+
+var columns = datafilter.Columns{
+	"input.resource.owner":  "owner",
+	"input.resource.public": "public",
+	"input.resource.kind":   "kind",
+	"input.resource.name":   "name",
+	"input.resource.size":   "size",
+}
+
+func newRuntime(t *testing.T) *runtime.Runtime {
+	t.Helper()
+
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{})
+	require.NoError(t, err)
+
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "filters.rego", []byte(filtersPolicy))
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func filter(t *testing.T, r *runtime.Runtime, user string, dialect datafilter.Dialect) *datafilter.SQLFilter {
+	t.Helper()
+
+	result, err := r.Compile(t.Context(), "data.filters.allowed == true", map[string]any{"user": user},
+		[]string{"input.resource"}, nil, false, false, false, types.ExplainOffV1)
+	require.NoError(t, err)
+
+	f, err := datafilter.SQL(result, columns, dialect)
+	require.NoError(t, err)
+
+	return f
+}
+
+func TestPostgres(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	r := newRuntime(t)
+
+	// Act
+	f := filter(t, r, "alice", datafilter.Postgres)
+
+	// Assert
+	assert.Contains(f.Where, "owner = $1")
+	assert.Contains(f.Where, "kind IN ($")
+	assert.Contains(f.Where, "name LIKE $")
+	assert.Contains(f.Args, "q1\\_%")
+	assert.NotContains(f.Where, "?")
+}
+
+func TestUnsupported(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	r := newRuntime(t)
+
+	large, err := r.Compile(t.Context(), "data.filters.large == true", nil,
+		[]string{"input.resource"}, nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	allowed, err := r.Compile(t.Context(), "data.filters.allowed == true", map[string]any{"user": "alice"},
+		[]string{"input.resource"}, nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Act
+	_, callErr := datafilter.SQL(large, columns, datafilter.SQLite)
+	_, columnErr := datafilter.SQL(allowed, datafilter.Columns{"input.resource.owner": "owner"}, datafilter.SQLite)
+
+	// Assert
+	var unsupported *datafilter.UnsupportedError
+	assert.ErrorAs(callErr, &unsupported)
+	assert.Equal("count cannot be translated", unsupported.Reason)
+
+	assert.ErrorIs(columnErr, datafilter.ErrUnsupported)
+	assert.ErrorContains(columnErr, "no column is mapped to input.resource.")
+}
+
+func TestSQLFromExpr(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		expr  string
+		where string
+		args  []any
+	}{
+		{
+			name:  "comparison",
+			rules: `allowed if input.resource.owner == "alice"`,
+			expr:  `{"op": "eq", "field": ["owner"], "value": "alice"}`,
+			where: "owner = $1",
+			args:  []any{"alice"},
+		},
+		{
+			name:  "flipped comparison",
+			rules: `allowed if 100 > input.resource.size`,
+			expr:  `{"op": "lt", "field": ["size"], "value": 100}`,
+			where: "size < $1",
+			args:  []any{int64(100)},
+		},
+		{
+			name:  "field comparison",
+			rules: `allowed if input.resource.owner != input.resource.kind`,
+			expr:  `{"op": "ne", "field": ["owner"], "other": ["kind"]}`,
+			where: "owner <> kind",
+		},
+		{
+			name:  "equal to null",
+			rules: `allowed if input.resource.owner == null`,
+			expr:  `{"op": "eq", "field": ["owner"]}`,
+			where: "owner IS NULL",
+		},
+		{
+			name:  "greater than null",
+			rules: `allowed if input.resource.size > null`,
+			expr:  `{"op": "gt", "field": ["size"]}`,
+			where: "size IS NOT NULL",
+		},
+		{
+			name:  "less than null",
+			rules: `allowed if input.resource.size < null`,
+			expr:  `{"op": "lt", "field": ["size"]}`,
+			where: "1 = 0",
+		},
+		{
+			name:  "membership with null",
+			rules: `allowed if input.resource.kind in ["report", null]`,
+			expr:  `{"op": "in", "field": ["kind"], "value": ["report", null]}`,
+			where: "(kind IN ($1) OR kind IS NULL)",
+			args:  []any{"report"},
+		},
+		{
+			name:  "startswith",
+			rules: `allowed if startswith(input.resource.name, "q1_")`,
+			expr:  `{"op": "startswith", "field": ["name"], "value": "q1_"}`,
+			where: `name LIKE $1 ESCAPE '\'`,
+			args:  []any{`q1\_%`},
+		},
+		{
+			name:  "negation",
+			rules: `allowed if not input.resource.public`,
+			expr:  `{"op": "not", "args": [{"op": "eq", "field": ["public"], "value": true}]}`,
+			where: "NOT (public = $1)",
+			args:  []any{true},
+		},
+		{
+			name: "queries",
+			rules: `allowed if {
+	input.resource.owner == "alice"
+	input.resource.size <= 10
+}
+
+allowed if input.resource.public`,
+			expr: `{"op": "or", "args": [
+				{"op": "eq", "field": ["public"], "value": true},
+				{"op": "and", "args": [
+					{"op": "eq", "field": ["owner"], "value": "alice"},
+					{"op": "lte", "field": ["size"], "value": 10}
+				]}
+			]}`,
+			where: "(public = $1) OR (owner = $2 AND size <= $3)",
+			args:  []any{true, "alice", int64(10)},
+		},
+		{
+			name:  "always",
+			rules: `allowed := true`,
+			expr:  `{"op": "true"}`,
+			where: "1 = 1",
+		},
+		{
+			name:  "never",
+			rules: `allowed if false`,
+			expr:  `{"op": "false"}`,
+			where: "1 = 0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			assert := require.New(t)
+			ctx := t.Context()
+
+			r, err := runtime.New(ctx, &runtime.Config{})
+			assert.NoError(err)
+
+			err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+				return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "cases.rego", []byte("package cases\n\nimport rego.v1\n\n"+tt.rules+"\n"))
+			})
+			assert.NoError(err)
+
+			result, err := r.Compile(ctx, "data.cases.allowed == true", nil,
+				[]string{"input.resource"}, nil, false, false, false, types.ExplainOffV1)
+			assert.NoError(err)
+
+			// Act
+			e, exprErr := datafilter.FromCompileResult(result, "input.resource")
+			f, sqlErr := datafilter.SQL(result, columns, datafilter.Postgres)
+
+			// Assert
+			assert.NoError(exprErr)
+			assert.NoError(sqlErr)
+
+			actual, err := json.Marshal(e)
+			assert.NoError(err)
+			assert.JSONEq(tt.expr, string(actual))
+
+			assert.Equal(tt.where, f.Where)
+			assert.Equal(tt.args, f.Args)
+		})
+	}
+}
@@ -0,0 +1,3 @@
+// Package sqlitetest runs the SQL filters of the datafilter package against an embedded SQLite database.
+// It is a separate module, so that the runtime does not depend on the cgo SQLite driver.
+package sqlitetest
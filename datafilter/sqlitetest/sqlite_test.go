@@ -0,0 +1,127 @@
+package sqlitetest_test
+
+import (
+	"database/sql"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/datafilter"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const filtersPolicy = `package filters
+
+import rego.v1
+
+allowed if input.resource.owner == input.user
+
+allowed if input.resource.public
+
+allowed if input.user == "admin"
+
+allowed if {
+	input.resource.kind in ["report", "invoice"]
+	startswith(input.resource.name, "q1_")
+	input.resource.size < 100
+}
+`
+
+var columns = datafilter.Columns{
+	"input.resource.owner":  "owner",
+	"input.resource.public": "public",
+	"input.resource.kind":   "kind",
+	"input.resource.name":   "name",
+	"input.resource.size":   "size",
+}
+
+func newRuntime(t *testing.T) *runtime.Runtime {
+	t.Helper()
+
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{})
+	require.NoError(t, err)
+
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "filters.rego", []byte(filtersPolicy))
+	})
+	require.NoError(t, err)
+
+	return r
+}
+
+func newDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+CREATE TABLE resources (id INTEGER, owner TEXT, public BOOLEAN, kind TEXT, name TEXT, size INTEGER);
+INSERT INTO resources VALUES
+	(1, 'alice', false, 'note', 'todo', 1),
+	(2, 'bob', true, 'note', 'shopping', 1),
+	(3, 'bob', false, 'report', 'q1_sales', 10),
+	(4, 'bob', false, 'report', 'q1_forecast', 500),
+	(5, 'bob', false, 'invoice', 'q2_acme', 10),
+	(6, 'carol', false, 'report', 'q1%', 10);
+`)
+	require.NoError(t, err)
+
+	return db
+}
+
+func filter(t *testing.T, r *runtime.Runtime, user string) *datafilter.SQLFilter {
+	t.Helper()
+
+	result, err := r.Compile(t.Context(), "data.filters.allowed == true", map[string]any{"user": user},
+		[]string{"input.resource"}, nil, false, false, false, types.ExplainOffV1)
+	require.NoError(t, err)
+
+	f, err := datafilter.SQL(result, columns, datafilter.SQLite)
+	require.NoError(t, err)
+
+	return f
+}
+
+func TestSQLite(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	r := newRuntime(t)
+	db := newDB(t)
+
+	query := func(f *datafilter.SQLFilter) []int {
+		rows, err := db.Query("SELECT id FROM resources WHERE "+f.Where+" ORDER BY id", f.Args...)
+		assert.NoError(err)
+
+		defer rows.Close()
+
+		ids := []int{}
+
+		for rows.Next() {
+			var id int
+			assert.NoError(rows.Scan(&id))
+
+			ids = append(ids, id)
+		}
+
+		assert.NoError(rows.Err())
+
+		return ids
+	}
+
+	// Act
+	alice := filter(t, r, "alice")
+	admin := filter(t, r, "admin")
+
+	// Assert
+	// the underscore of "q1_" is escaped, so it doesn't match "q1%".
+	assert.Equal([]int{1, 2, 3}, query(alice))
+	assert.Equal([]int{1, 2, 3, 4, 5, 6}, query(admin))
+	assert.Empty(admin.Args)
+}
@@ -0,0 +1,102 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/pkg/errors"
+)
+
+// Paths of the decisions evaluated by Decide and Authorize when OPAConfig.DefaultDecision
+// and OPAConfig.DefaultAuthorizationDecision are not set, as in OPA.
+const (
+	defaultDecision              = "/system/main"
+	defaultAuthorizationDecision = "/system/authz/allow"
+)
+
+// Decision is the result of Decide.
+type Decision struct {
+	DecisionID string
+	// Path is the reference of the rule the decision was evaluated from, e.g. "data.system.main".
+	Path     string
+	Value    any
+	Metadata map[string]any
+}
+
+// AuthorizationDecision is the result of Authorize.
+type AuthorizationDecision struct {
+	DecisionID string
+	// Path is the reference of the rule the decision was evaluated from, e.g. "data.system.authz.allow".
+	Path     string
+	Allowed  bool
+	Metadata map[string]any
+}
+
+// Decide evaluates the default decision, OPAConfig.DefaultDecision, with input.
+// As with OPA's POST / API, the path defaults to "/system/main".
+// It returns ErrUndefined if the decision is undefined for the given input.
+func (r *Runtime) Decide(ctx context.Context, input map[string]any, opts ...QueryOption) (*Decision, error) {
+	path := defaultDecision
+	if r.Config.Config.DefaultDecision != nil {
+		path = *r.Config.Config.DefaultDecision
+	}
+
+	ref, result, err := r.decide(ctx, path, input, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Decision{
+		DecisionID: result.DecisionID,
+		Path:       ref.String(),
+		Value:      result.Result[0].Expressions[0].Value,
+		Metadata:   result.Metadata,
+	}, nil
+}
+
+// Authorize evaluates the default authorization decision, OPAConfig.DefaultAuthorizationDecision, with input.
+// As in OPA, the path defaults to "/system/authz/allow" and the decision must be a boolean.
+// It returns ErrUndefined if the decision is undefined for the given input.
+func (r *Runtime) Authorize(ctx context.Context, input map[string]any, opts ...QueryOption) (*AuthorizationDecision, error) {
+	path := defaultAuthorizationDecision
+	if r.Config.Config.DefaultAuthorizationDecision != nil {
+		path = *r.Config.Config.DefaultAuthorizationDecision
+	}
+
+	ref, result, err := r.decide(ctx, path, input, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, ok := result.Result[0].Expressions[0].Value.(bool)
+	if !ok {
+		return nil, errors.Errorf("authorization decision [%s] must be a boolean, got %T", ref, result.Result[0].Expressions[0].Value)
+	}
+
+	return &AuthorizationDecision{
+		DecisionID: result.DecisionID,
+		Path:       ref.String(),
+		Allowed:    allowed,
+		Metadata:   result.Metadata,
+	}, nil
+}
+
+// decide evaluates the rule at path, a slash-separated path under data, and checks that it is defined.
+func (r *Runtime) decide(ctx context.Context, path string, input map[string]any, opts []QueryOption) (ast.Ref, *Result, error) {
+	ref, err := ast.PtrRef(ast.DefaultRootDocument, path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "invalid decision path [%s]", path)
+	}
+
+	result, err := r.Query(ctx, ref.String(), input, false, false, false, types.ExplainOffV1, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(result.Result) == 0 || len(result.Result[0].Expressions) == 0 {
+		return nil, nil, errors.Wrapf(ErrUndefined, "decision [%s]", ref)
+	}
+
+	return ref, result, nil
+}
@@ -0,0 +1,68 @@
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const decidePolicy = `package system
+
+import rego.v1
+
+main := {"allowed": authz.allow}
+
+authz.allow if input.user == "alice"
+
+authz.role := "admin"
+`
+
+func TestDecide(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	role := "/system/authz/role"
+
+	defaults, err := runtime.New(ctx, &runtime.Config{})
+	assert.NoError(err)
+
+	custom, err := runtime.New(ctx, &runtime.Config{
+		Config: runtime.OPAConfig{DefaultAuthorizationDecision: &role},
+	})
+	assert.NoError(err)
+
+	for _, r := range []*runtime.Runtime{defaults, custom} {
+		err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+			return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "system.rego", []byte(decidePolicy))
+		})
+		assert.NoError(err)
+	}
+
+	alice := map[string]any{"user": "alice"}
+	bob := map[string]any{"user": "bob"}
+
+	// Act
+	decision, err := defaults.Decide(ctx, alice)
+	assert.NoError(err)
+
+	allowed, err := defaults.Authorize(ctx, alice, runtime.WithDecisionID("authz-1"))
+	assert.NoError(err)
+
+	_, undefinedErr := defaults.Authorize(ctx, bob)
+	_, notBoolErr := custom.Authorize(ctx, alice)
+
+	// Assert
+	assert.Equal("data.system.main", decision.Path)
+	assert.Equal(map[string]any{"allowed": true}, decision.Value)
+	assert.NotEmpty(decision.DecisionID)
+
+	assert.Equal("data.system.authz.allow", allowed.Path)
+	assert.True(allowed.Allowed)
+	assert.Equal("authz-1", allowed.DecisionID)
+
+	assert.ErrorIs(undefinedErr, runtime.ErrUndefined)
+	assert.ErrorContains(notBoolErr, "data.system.authz.role")
+}
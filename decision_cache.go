@@ -0,0 +1,394 @@
+package runtime
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/topdown/builtins"
+	"github.com/pkg/errors"
+)
+
+const (
+	// MetricDecisionCacheHit counts queries served from the decision cache.
+	MetricDecisionCacheHit = "runtime_decision_cache_hit"
+	// MetricDecisionCacheMiss counts cacheable queries that had to be evaluated.
+	MetricDecisionCacheMiss = "runtime_decision_cache_miss"
+)
+
+// DecisionCacheStats describes the activity of the decision cache.
+type DecisionCacheStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+	Flushes   uint64 `json:"flushes"`
+	Size      int    `json:"size"`
+}
+
+type decisionCacheEntry struct {
+	key     string
+	result  rego.ResultSet
+	expires time.Time
+}
+
+// decisionCache is an LRU cache of query results, keyed by query and input.
+// Entries expire after a TTL, and the whole cache is flushed when policies or data change.
+type decisionCache struct {
+	mu      sync.Mutex
+	maxSize int
+	ttl     time.Duration
+	lru     *list.List
+	entries map[string]*list.Element
+	// generation is incremented by every flush, so results evaluated before a flush are not cached after it.
+	generation uint64
+	stats      DecisionCacheStats
+}
+
+func newDecisionCache(maxSize int, ttl time.Duration) *decisionCache {
+	return &decisionCache{
+		maxSize: maxSize,
+		ttl:     ttl,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// decisionCacheKey returns the cache key of a query, and false if the query cannot be served from the cache.
+func (r *Runtime) decisionCacheKey(
+	parsedQuery ast.Body,
+	input map[string]any,
+	explainMode types.ExplainModeV1,
+	includeInstrumentation bool,
+	qo *queryOptions,
+) (string, bool) {
+	if r.decisionCache == nil || qo.noDecisionCache || len(qo.modules) > 0 ||
+		explainMode != types.ExplainOffV1 || qo.explanationText || includeInstrumentation {
+		return "", false
+	}
+
+	// json.Marshal sorts map keys, so equal inputs have the same encoding.
+	bs, err := json.Marshal(input)
+	if err != nil {
+		return "", false
+	}
+
+	sum := sha256.Sum256(bs)
+
+	return parsedQuery.String() + "|" + hex.EncodeToString(sum[:]), true
+}
+
+// decisionCacheMiss is a cacheable query that was not found in the decision cache. Its result is put in
+// the cache, unless the evaluation called a non-deterministic builtin or a custom builtin that is not
+// declared cacheable, see WithCacheableBuiltins.
+type decisionCacheMiss struct {
+	cache       *decisionCache
+	key         string
+	generation  uint64
+	ndbc        builtins.NDBCache
+	uncacheable atomic.Bool
+}
+
+type decisionCacheMissKey struct{}
+
+// cachedDecision looks the result of a query up in the decision cache. On a miss, it returns the
+// decisionCacheMiss the result of the evaluation is put with, or nil if the query is not cacheable.
+func (r *Runtime) cachedDecision(
+	parsedQuery ast.Body,
+	input map[string]any,
+	m metrics.Metrics,
+	explainMode types.ExplainModeV1,
+	includeInstrumentation, bypass bool,
+	qo *queryOptions,
+) (rego.ResultSet, *decisionCacheMiss, bool) {
+	if bypass {
+		return nil, nil, false
+	}
+
+	key, ok := r.decisionCacheKey(parsedQuery, input, explainMode, includeInstrumentation, qo)
+	if !ok {
+		return nil, nil, false
+	}
+
+	if result, ok := r.decisionCache.get(key); ok {
+		m.Counter(MetricDecisionCacheHit).Incr()
+		return result, nil, true
+	}
+
+	m.Counter(MetricDecisionCacheMiss).Incr()
+
+	return nil, &decisionCacheMiss{
+		cache:      r.decisionCache,
+		key:        key,
+		generation: qo.cacheGeneration,
+		ndbc:       builtins.NDBCache{},
+	}, false
+}
+
+// context returns a context custom builtins mark the miss as uncacheable through.
+func (m *decisionCacheMiss) context(ctx context.Context) context.Context {
+	if m == nil {
+		return ctx
+	}
+
+	return context.WithValue(ctx, decisionCacheMissKey{}, m)
+}
+
+// evalOptions records the calls to non-deterministic builtins (e.g. http.send or time.now_ns).
+func (m *decisionCacheMiss) evalOptions() []rego.EvalOption {
+	if m == nil {
+		return nil
+	}
+
+	return []rego.EvalOption{rego.EvalNDBuiltinCache(m.ndbc)}
+}
+
+func (m *decisionCacheMiss) put(result rego.ResultSet) {
+	if m == nil || m.uncacheable.Load() || len(m.ndbc) > 0 {
+		return
+	}
+
+	m.cache.put(m.key, m.generation, result)
+}
+
+// markUncacheable prevents the result of the query evaluated with ctx from being cached.
+func markUncacheable(ctx context.Context) {
+	if m, ok := ctx.Value(decisionCacheMissKey{}).(*decisionCacheMiss); ok {
+		m.uncacheable.Store(true)
+	}
+}
+
+// currentGeneration returns the generation the result of a query must be put with. It is read before
+// opening the query's transaction, so that a result evaluated against policies and data older than a flush
+// is not cached after it.
+func (c *decisionCache) currentGeneration() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.generation
+}
+
+// newQueryTransaction opens the read transaction of a query, see currentGeneration.
+func (r *Runtime) newQueryTransaction(ctx context.Context, qo *queryOptions) (storage.Transaction, error) {
+	qo.cacheGeneration = r.decisionCache.currentGeneration()
+
+	return r.storage.NewTransaction(ctx)
+}
+
+// get returns a copy of the cached result of key.
+func (c *decisionCache) get(key string) (rego.ResultSet, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok || time.Now().After(elem.Value.(*decisionCacheEntry).expires) {
+		if ok {
+			c.remove(elem)
+		}
+
+		c.stats.Misses++
+
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.lru.MoveToFront(elem)
+
+	return copyResultSet(elem.Value.(*decisionCacheEntry).result), true
+}
+
+func (c *decisionCache) put(key string, generation uint64, result rego.ResultSet) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	for c.lru.Len() >= c.maxSize {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+
+	c.entries[key] = c.lru.PushFront(&decisionCacheEntry{
+		key:     key,
+		result:  copyResultSet(result),
+		expires: time.Now().Add(c.ttl),
+	})
+}
+
+func (c *decisionCache) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*decisionCacheEntry).key)
+}
+
+func (c *decisionCache) flush() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.entries)
+	c.generation++
+	c.stats.Flushes++
+}
+
+// registerDecisionCacheTrigger flushes the decision cache whenever policies or data are written to the store,
+// which includes bundle activations.
+func (r *Runtime) registerDecisionCacheTrigger(ctx context.Context) error {
+	if r.decisionCache == nil {
+		return nil
+	}
+
+	return storage.Txn(ctx, r.storage, storage.WriteParams, func(txn storage.Transaction) error {
+		_, err := r.storage.Register(ctx, txn, storage.TriggerConfig{
+			OnCommit: func(_ context.Context, _ storage.Transaction, event storage.TriggerEvent) {
+				if !event.IsZero() {
+					r.decisionCache.flush()
+				}
+			},
+		})
+
+		return err
+	})
+}
+
+// DecisionCacheStats returns the statistics of the decision cache.
+func (r *Runtime) DecisionCacheStats() (DecisionCacheStats, error) {
+	if r.decisionCache == nil {
+		return DecisionCacheStats{}, errors.New("decision cache is not enabled")
+	}
+
+	r.decisionCache.mu.Lock()
+	defer r.decisionCache.mu.Unlock()
+
+	stats := r.decisionCache.stats
+	stats.Size = r.decisionCache.lru.Len()
+
+	return stats, nil
+}
+
+// FlushDecisionCache discards all cached decisions.
+func (r *Runtime) FlushDecisionCache() {
+	r.decisionCache.flush()
+}
+
+// copyResultSet copies a result set, so callers cannot modify cached results.
+func copyResultSet(rs rego.ResultSet) rego.ResultSet {
+	if rs == nil {
+		return nil
+	}
+
+	cp := make(rego.ResultSet, len(rs))
+
+	for i, result := range rs {
+		cp[i].Expressions = make([]*rego.ExpressionValue, len(result.Expressions))
+		for j, expr := range result.Expressions {
+			cp[i].Expressions[j] = &rego.ExpressionValue{
+				Value:    copyValue(expr.Value),
+				Text:     expr.Text,
+				Location: expr.Location,
+			}
+		}
+
+		if result.Bindings != nil {
+			cp[i].Bindings = make(rego.Vars, len(result.Bindings))
+			for k, v := range result.Bindings {
+				cp[i].Bindings[k] = copyValue(v)
+			}
+		}
+	}
+
+	return cp
+}
+
+func copyValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		cp := maps.Clone(v)
+		for k, e := range cp {
+			cp[k] = copyValue(e)
+		}
+
+		return cp
+	case []any:
+		cp := make([]any, len(v))
+		for i, e := range v {
+			cp[i] = copyValue(e)
+		}
+
+		return cp
+	}
+
+	return v
+}
+
+// isCacheableBuiltin returns true if the decisions calling the custom builtin can be cached,
+// see WithCacheableBuiltins.
+func (r *Runtime) isCacheableBuiltin(decl *rego.Function) bool {
+	if r.decisionCache == nil {
+		return true
+	}
+
+	_, ok := r.cacheableBuiltins[decl.Name]
+
+	return ok
+}
+
+// uncacheable1 .. uncacheableDyn wrap the implementation of a custom builtin, so that the decisions calling it
+// are not cached.
+func uncacheable1(impl rego.Builtin1) rego.Builtin1 {
+	return func(bctx rego.BuiltinContext, op1 *ast.Term) (*ast.Term, error) {
+		markUncacheable(bctx.Context)
+		return impl(bctx, op1)
+	}
+}
+
+func uncacheable2(impl rego.Builtin2) rego.Builtin2 {
+	return func(bctx rego.BuiltinContext, op1, op2 *ast.Term) (*ast.Term, error) {
+		markUncacheable(bctx.Context)
+		return impl(bctx, op1, op2)
+	}
+}
+
+func uncacheable3(impl rego.Builtin3) rego.Builtin3 {
+	return func(bctx rego.BuiltinContext, op1, op2, op3 *ast.Term) (*ast.Term, error) {
+		markUncacheable(bctx.Context)
+		return impl(bctx, op1, op2, op3)
+	}
+}
+
+func uncacheable4(impl rego.Builtin4) rego.Builtin4 {
+	return func(bctx rego.BuiltinContext, op1, op2, op3, op4 *ast.Term) (*ast.Term, error) {
+		markUncacheable(bctx.Context)
+		return impl(bctx, op1, op2, op3, op4)
+	}
+}
+
+func uncacheableDyn(impl rego.BuiltinDyn) rego.BuiltinDyn {
+	return func(bctx rego.BuiltinContext, terms []*ast.Term) (*ast.Term, error) {
+		markUncacheable(bctx.Context)
+		return impl(bctx, terms)
+	}
+}
@@ -0,0 +1,150 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionCache(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSchemaBundle()},
+		},
+	}, runtime.WithDecisionCache(10, time.Minute))
+	assert.NoError(err)
+
+	query := func(q string, input map[string]any, opts ...runtime.QueryOption) *runtime.Result {
+		result, err := r.Query(ctx, q, input, false, true, false, types.ExplainOffV1, opts...)
+		assert.NoError(err)
+
+		return result
+	}
+
+	alice := map[string]any{"user": "alice"}
+
+	// Act & Assert
+	assert.Contains(query("data.schema.allowed", alice).Metrics, "counter_"+runtime.MetricDecisionCacheMiss)
+
+	hit := query("data.schema.allowed", alice)
+	assert.Contains(hit.Metrics, "counter_"+runtime.MetricDecisionCacheHit)
+	assert.Equal(true, hit.Result[0].Expressions[0].Value)
+
+	// a different input is a different decision.
+	miss := query("data.schema.allowed", map[string]any{"user": "bob"})
+	assert.Contains(miss.Metrics, "counter_"+runtime.MetricDecisionCacheMiss)
+	assert.Empty(miss.Result)
+
+	assert.NotContains(query("data.schema.allowed", alice, runtime.WithoutDecisionCache()).Metrics,
+		"counter_"+runtime.MetricDecisionCacheHit)
+
+	// results depending on non-deterministic builtins are not cached.
+	query("x = time.now_ns()", nil)
+	assert.Contains(query("x = time.now_ns()", nil).Metrics, "counter_"+runtime.MetricDecisionCacheMiss)
+
+	stats, err := r.DecisionCacheStats()
+	assert.NoError(err)
+	assert.Equal(uint64(1), stats.Hits)
+	assert.Equal(uint64(4), stats.Misses)
+	assert.Equal(2, stats.Size)
+
+	flushes := stats.Flushes
+
+	// writing data flushes the cache.
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.Write(ctx, txn, storage.AddOp, storage.MustParsePath("/users"), map[string]any{})
+	})
+	assert.NoError(err)
+
+	assert.Contains(query("data.schema.allowed", alice).Metrics, "counter_"+runtime.MetricDecisionCacheMiss)
+
+	stats, err = r.DecisionCacheStats()
+	assert.NoError(err)
+	assert.Equal(flushes+1, stats.Flushes)
+	assert.Equal(1, stats.Size)
+}
+
+// flushingStore flushes the decision cache of a runtime right after opening each transaction, as if
+// a bundle was activated while a query was starting.
+type flushingStore struct {
+	storage.Store
+
+	r *runtime.Runtime
+}
+
+func (s *flushingStore) NewTransaction(ctx context.Context, params ...storage.TransactionParams) (storage.Transaction, error) {
+	txn, err := s.Store.NewTransaction(ctx, params...)
+	if s.r != nil {
+		s.r.FlushDecisionCache()
+	}
+
+	return txn, err
+}
+
+func TestDecisionCacheFlushDuringQuery(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+	store := &flushingStore{Store: inmem.New()}
+
+	r, err := runtime.New(ctx, &runtime.Config{}, runtime.WithStorage(store), runtime.WithDecisionCache(10, time.Minute))
+	assert.NoError(err)
+
+	store.r = r
+
+	// Act
+	_, err = r.Query(ctx, "x = 1", nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Assert
+	stats, err := r.DecisionCacheStats()
+	assert.NoError(err)
+	assert.Equal(0, stats.Size)
+}
+
+func TestDecisionCacheCustomBuiltin(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []runtime.Option
+		cacheable bool
+	}{
+		{"region_uncacheable", nil, false},
+		{"region_cacheable", []runtime.Option{runtime.WithCacheableBuiltins("test.region_cacheable")}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			assert := require.New(t)
+			r := compileRuntime(t, tt.name, false, append(tt.opts, runtime.WithDecisionCache(10, time.Minute))...)
+			input := map[string]any{"tenant": "acme", "resource": map[string]any{"region": "eu-acme"}}
+
+			// Act
+			_, err := r.Query(t.Context(), "x = compiletest.allowed", input, false, false, false, types.ExplainOffV1)
+			assert.NoError(err)
+
+			result, err := r.Query(t.Context(), "x = compiletest.allowed", input, false, true, false, types.ExplainOffV1)
+
+			// Assert
+			assert.NoError(err)
+			assert.Equal(true, result.Result[0].Bindings["x"])
+
+			if tt.cacheable {
+				assert.Contains(result.Metrics, "counter_"+runtime.MetricDecisionCacheHit)
+			} else {
+				assert.Contains(result.Metrics, "counter_"+runtime.MetricDecisionCacheMiss)
+			}
+		})
+	}
+}
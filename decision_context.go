@@ -0,0 +1,72 @@
+package runtime
+
+import (
+	"context"
+	"maps"
+
+	"github.com/google/uuid"
+	"github.com/open-policy-agent/opa/v1/logging"
+)
+
+type decisionMetadataKey struct{}
+
+// ContextWithDecisionID returns a context that makes Query, Compile and the other evaluation methods
+// use id as the decision ID, instead of generating one. WithDecisionID takes precedence over it.
+// The ID is stored the same way OPA stores it, see logging.WithDecisionID.
+func ContextWithDecisionID(ctx context.Context, id string) context.Context {
+	return logging.WithDecisionID(ctx, id)
+}
+
+// DecisionIDFromContext returns the decision ID of the evaluation, if any. Custom builtins can use it
+// with the context of their rego.BuiltinContext.
+func DecisionIDFromContext(ctx context.Context) (string, bool) {
+	return logging.DecisionIDFromContext(ctx)
+}
+
+// ContextWithDecisionMetadata returns a context carrying metadata (e.g. tenant, caller or request path)
+// for the decisions evaluated with it. It is merged with the metadata already in ctx.
+func ContextWithDecisionMetadata(ctx context.Context, metadata map[string]any) context.Context {
+	md := maps.Clone(DecisionMetadataFromContext(ctx))
+	if md == nil {
+		md = make(map[string]any, len(metadata))
+	}
+
+	maps.Copy(md, metadata)
+
+	return context.WithValue(ctx, decisionMetadataKey{}, md)
+}
+
+// DecisionMetadataFromContext returns the decision metadata of the evaluation, if any.
+// The returned map must not be modified.
+func DecisionMetadataFromContext(ctx context.Context) map[string]any {
+	md, _ := ctx.Value(decisionMetadataKey{}).(map[string]any)
+	return md
+}
+
+// decisionContext resolves the decision ID and metadata of an evaluation from its options and context.
+// The returned context carries both, along with a logger tagged with them, so builtins can retrieve them.
+func (r *Runtime) decisionContext(ctx context.Context, qo *queryOptions) (context.Context, string) {
+	decisionID := qo.decisionID
+	if decisionID == "" {
+		decisionID, _ = DecisionIDFromContext(ctx)
+	}
+
+	if decisionID == "" {
+		decisionID = uuid.New().String()
+	}
+
+	return r.newDecisionContext(ctx, decisionID, qo)
+}
+
+func (r *Runtime) newDecisionContext(ctx context.Context, decisionID string, qo *queryOptions) (context.Context, string) {
+	if len(qo.metadata) > 0 {
+		ctx = ContextWithDecisionMetadata(ctx, qo.metadata)
+	}
+
+	logger := r.Logger.With().Str("decisionID", decisionID).Fields(DecisionMetadataFromContext(ctx)).Logger()
+
+	ctx = ContextWithDecisionID(ctx, decisionID)
+	ctx = logger.WithContext(ctx)
+
+	return ctx, decisionID
+}
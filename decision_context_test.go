@@ -0,0 +1,70 @@
+package runtime_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	opatypes "github.com/open-policy-agent/opa/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+func decisionIDBuiltin() runtime.Option {
+	return runtime.WithBuiltinDyn(&rego.Function{
+		Name: "test.decision_id",
+		Decl: opatypes.NewFunction(nil, opatypes.S),
+	}, func(bctx rego.BuiltinContext, _ []*ast.Term) (*ast.Term, error) {
+		id, _ := runtime.DecisionIDFromContext(bctx.Context)
+		return ast.StringTerm(id), nil
+	})
+}
+
+func TestDecisionContext(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+
+	r, err := runtime.New(t.Context(), &runtime.Config{},
+		decisionIDBuiltin(),
+		runtime.WithDecisionLogger(runtime.NewWriterDecisionLogger(&buf)),
+	)
+	assert.NoError(err)
+
+	ctx := runtime.ContextWithDecisionMetadata(t.Context(), map[string]any{"caller": "svc", "tenant": "default"})
+
+	// Act
+	fromOption, err := r.Query(ctx, "x = test.decision_id()", nil, false, false, false, types.ExplainOffV1,
+		runtime.WithDecisionID("req-1"),
+		runtime.WithDecisionMetadata(map[string]any{"tenant": "acme"}),
+	)
+	assert.NoError(err)
+
+	fromContext, err := r.Query(runtime.ContextWithDecisionID(ctx, "req-2"), "x = test.decision_id()", nil,
+		false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	generated, err := r.Compile(t.Context(), "x = test.decision_id()", nil, nil, nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Assert
+	assert.Equal("req-1", fromOption.DecisionID)
+	assert.Equal("req-1", fromOption.Result[0].Bindings["x"])
+	assert.Equal(map[string]any{"caller": "svc", "tenant": "acme"}, fromOption.Metadata)
+
+	assert.Equal("req-2", fromContext.DecisionID)
+	assert.Equal("req-2", fromContext.Result[0].Bindings["x"])
+	assert.Equal(map[string]any{"caller": "svc", "tenant": "default"}, fromContext.Metadata)
+
+	assert.NotEmpty(generated.DecisionID)
+	assert.Nil(generated.Metadata)
+
+	var event map[string]any
+	assert.NoError(json.Unmarshal(bytes.Split(buf.Bytes(), []byte("\n"))[0], &event))
+	assert.Equal("req-1", event["decision_id"])
+	assert.Equal(map[string]any{"caller": "svc", "tenant": "acme"}, event["custom"])
+}
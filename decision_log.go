@@ -0,0 +1,248 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/plugins/logs"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/util"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const defaultMaskDecision = "system/log/mask"
+
+// DecisionLogger receives a decision event for every Query and Compile call.
+// It matches the Log method of OPA's logs.Logger, so custom decision log plugins can be used as sinks.
+// Events are masked using the mask decision (data.system.log.mask by default) before being logged.
+type DecisionLogger interface {
+	Log(ctx context.Context, event logs.EventV1) error
+}
+
+// WriterDecisionLogger writes decision events to an io.Writer, one JSON document per line.
+type WriterDecisionLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterDecisionLogger creates a DecisionLogger that writes events to w, e.g. os.Stdout or a file.
+func NewWriterDecisionLogger(w io.Writer) *WriterDecisionLogger {
+	return &WriterDecisionLogger{w: w}
+}
+
+func (l *WriterDecisionLogger) Log(_ context.Context, event logs.EventV1) error {
+	bs, err := json.Marshal(event)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal decision event")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, err = l.w.Write(append(bs, '\n'))
+
+	return errors.Wrap(err, "failed to write decision event")
+}
+
+// decision holds everything known about an evaluated Query or Compile call.
+type decision struct {
+	txn        storage.Transaction
+	decisionID string
+	query      string
+	input      map[string]any
+	metadata   map[string]any
+	results    any
+	metrics    metrics.Metrics
+	err        error
+	timestamp  time.Time
+}
+
+// logDecision routes the decision to the configured DecisionLogger or, if there is none,
+// to the OPA decision_logs plugin. Failures are logged and never fail the query.
+func (r *Runtime) logDecision(ctx context.Context, d *decision) {
+	var err error
+
+	switch {
+	case r.decisionLogger != nil:
+		err = r.logDecisionEvent(ctx, d)
+	case logs.Lookup(r.pluginsManager) != nil:
+		err = logs.Lookup(r.pluginsManager).Log(ctx, r.decisionInfo(ctx, d))
+	default:
+		return
+	}
+
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to log decision")
+	}
+}
+
+func (r *Runtime) decisionInfo(ctx context.Context, d *decision) *server.Info {
+	info := &server.Info{
+		Txn:        d.txn,
+		DecisionID: d.decisionID,
+		Query:      d.query,
+		Timestamp:  d.timestamp,
+		Metrics:    d.metrics,
+		Error:      d.err,
+		Bundles:    map[string]server.BundleInfo{},
+		Custom:     d.metadata,
+	}
+
+	for name, revision := range r.bundleRevisions(ctx, d.txn) {
+		info.Bundles[name] = server.BundleInfo{Revision: revision}
+	}
+
+	if d.input != nil {
+		var input any = d.input
+		info.Input = &input
+	}
+
+	if d.results != nil {
+		info.Results = &d.results
+	}
+
+	return info
+}
+
+func (r *Runtime) logDecisionEvent(ctx context.Context, d *decision) error {
+	event := logs.EventV1{
+		Labels:     r.pluginsManager.Labels(),
+		DecisionID: d.decisionID,
+		Query:      d.query,
+		Timestamp:  d.timestamp,
+		Error:      d.err,
+		Bundles:    map[string]logs.BundleInfoV1{},
+		Custom:     d.metadata,
+	}
+
+	for name, revision := range r.bundleRevisions(ctx, d.txn) {
+		event.Bundles[name] = logs.BundleInfoV1{Revision: revision}
+	}
+
+	if d.metrics != nil {
+		event.Metrics = d.metrics.All()
+	}
+
+	// copy input and results, so masking doesn't modify the values returned to the caller.
+	if d.input != nil {
+		var input any = d.input
+		if err := util.RoundTrip(&input); err != nil {
+			return errors.Wrap(err, "failed to copy decision input")
+		}
+
+		event.Input = &input
+	}
+
+	if d.results != nil {
+		results := d.results
+		if err := util.RoundTrip(&results); err != nil {
+			return errors.Wrap(err, "failed to copy decision results")
+		}
+
+		event.Result = &results
+	}
+
+	if err := r.maskDecisionEvent(ctx, d.txn, &event); err != nil {
+		return errors.Wrap(err, "failed to mask decision event")
+	}
+
+	return r.decisionLogger.Log(ctx, event)
+}
+
+// bundleRevisions returns the revision of each bundle activated in the store.
+func (r *Runtime) bundleRevisions(ctx context.Context, txn storage.Transaction) map[string]string {
+	revisions := map[string]string{}
+
+	names, err := bundle.ReadBundleNamesFromStore(ctx, r.storage, txn)
+	if err != nil {
+		return revisions
+	}
+
+	for _, name := range names {
+		if revision, err := bundle.ReadBundleRevisionFromStore(ctx, r.storage, txn, name); err == nil {
+			revisions[name] = revision
+		}
+	}
+
+	return revisions
+}
+
+// setupMaskDecision parses the mask decision of decision_logs, or the default one.
+func (r *Runtime) setupMaskDecision() error {
+	maskDecision := defaultMaskDecision
+	if cfg := r.Config.Config.DecisionLogs; cfg != nil && cfg.MaskDecision != nil {
+		maskDecision = *cfg.MaskDecision
+	}
+
+	ref, err := ast.PtrRef(ast.DefaultRootDocument, maskDecision)
+	if err != nil {
+		return errors.Wrapf(err, "invalid mask decision [%s]", maskDecision)
+	}
+
+	r.maskDecision = ref
+
+	return nil
+}
+
+// preparedMask returns the mask query, prepared once per compiler.
+func (r *Runtime) preparedMask(ctx context.Context, txn storage.Transaction) (rego.PreparedEvalQuery, error) {
+	compiler := r.pluginsManager.GetCompiler()
+
+	if entry := r.maskQuery.Load(); entry != nil && entry.compiler == compiler {
+		return entry.query, nil
+	}
+
+	opts := append(r.regoOptions(txn, ast.NewBody(ast.NewExpr(ast.NewTerm(r.maskDecision))), metrics.New()),
+		rego.Compiler(compiler))
+
+	pq, err := rego.New(opts...).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+
+	r.maskQuery.Store(&preparedQueryEntry[rego.PreparedEvalQuery]{compiler: compiler, query: pq})
+
+	return pq, nil
+}
+
+// maskDecisionEvent evaluates the mask decision with the event as input and applies the resulting rules.
+func (r *Runtime) maskDecisionEvent(ctx context.Context, txn storage.Transaction, event *logs.EventV1) error {
+	input, err := event.AST()
+	if err != nil {
+		return err
+	}
+
+	pq, err := r.preparedMask(ctx, txn)
+	if err != nil {
+		return err
+	}
+
+	rs, err := pq.Eval(ctx, rego.EvalParsedInput(input), rego.EvalTransaction(txn))
+	if err != nil {
+		return err
+	} else if len(rs) == 0 {
+		return nil
+	}
+
+	rules, err := parseMaskRules(rs[0].Expressions[0].Value)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if err := rule.apply(event); err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Str("path", rule.Path).Msg("mask rule skipped")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package runtime
+
+import (
+	"slices"
+	"strconv"
+
+	"github.com/open-policy-agent/opa/v1/plugins/logs"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	maskOpRemove = "remove"
+	maskOpUpsert = "upsert"
+)
+
+var errMaskPath = errors.New("mask path not found")
+
+// maskRule is an OPA decision log mask rule, e.g. "/input/password" or
+// {"op": "upsert", "path": "/input/password", "value": "***"}.
+type maskRule struct {
+	Op    string
+	Path  string
+	Value any
+
+	parts storage.Path
+}
+
+func parseMaskRules(v any) ([]*maskRule, error) {
+	raw, ok := v.([]any)
+	if !ok {
+		return nil, errors.Errorf("unexpected mask rules format %v (%[1]T)", v)
+	}
+
+	rules := make([]*maskRule, 0, len(raw))
+
+	for _, item := range raw {
+		rule := &maskRule{Op: maskOpRemove}
+
+		switch item := item.(type) {
+		case string:
+			rule.Path = item
+		case map[string]any:
+			if op, ok := item["op"].(string); ok {
+				rule.Op = op
+			}
+
+			rule.Path, _ = item["path"].(string)
+			rule.Value = item["value"]
+		default:
+			return nil, errors.Errorf("invalid mask rule format %v (%[1]T)", item)
+		}
+
+		if rule.Op != maskOpRemove && rule.Op != maskOpUpsert {
+			return nil, errors.Errorf("invalid mask rule op [%s]", rule.Op)
+		}
+
+		parts, ok := storage.ParsePathEscaped(rule.Path)
+		if !ok || len(parts) == 0 || (parts[0] != "input" && parts[0] != "result") {
+			return nil, errors.Errorf("invalid mask rule path [%s]", rule.Path)
+		}
+
+		rule.parts = parts
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+func (m *maskRule) apply(event *logs.EventV1) error {
+	target := &event.Input
+	if m.parts[0] == "result" {
+		target = &event.Result
+	}
+
+	if *target == nil {
+		return nil
+	}
+
+	switch {
+	case m.Op == maskOpRemove && len(m.parts) == 1:
+		*target = nil
+	case m.Op == maskOpRemove:
+		value, err := removeValue(**target, m.parts[1:])
+		if err != nil {
+			return err
+		}
+
+		**target = value
+	case len(m.parts) == 1:
+		value := m.Value
+		*target = &value
+	default:
+		if err := upsertValue(**target, m.parts[1:], m.Value); err != nil {
+			return err
+		}
+	}
+
+	if m.Op == maskOpRemove {
+		event.Erased = append(event.Erased, m.parts.String())
+	} else {
+		event.Masked = append(event.Masked, m.parts.String())
+	}
+
+	return nil
+}
+
+// removeValue removes the value at path from node and returns the updated node.
+func removeValue(node any, path []string) (any, error) {
+	key := path[0]
+
+	switch v := node.(type) {
+	case map[string]any:
+		child, ok := v[key]
+		if !ok {
+			return nil, errMaskPath
+		}
+
+		if len(path) == 1 {
+			delete(v, key)
+			return v, nil
+		}
+
+		child, err := removeValue(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		v[key] = child
+
+		return v, nil
+
+	case []any:
+		idx, err := strconv.Atoi(key)
+		if err != nil || idx < 0 || idx >= len(v) {
+			return nil, errMaskPath
+		}
+
+		if len(path) == 1 {
+			return slices.Delete(v, idx, idx+1), nil
+		}
+
+		child, err := removeValue(v[idx], path[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		v[idx] = child
+
+		return v, nil
+	}
+
+	return nil, errMaskPath
+}
+
+// upsertValue sets the value at path in node, creating intermediate objects as needed.
+func upsertValue(node any, path []string, value any) error {
+	for i, key := range path {
+		last := i == len(path)-1
+
+		switch v := node.(type) {
+		case map[string]any:
+			if last {
+				v[key] = value
+				return nil
+			}
+
+			child, ok := v[key]
+			if !ok {
+				child = map[string]any{}
+				v[key] = child
+			}
+
+			node = child
+
+		case []any:
+			idx, err := strconv.Atoi(key)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return errMaskPath
+			}
+
+			if last {
+				v[idx] = value
+				return nil
+			}
+
+			node = v[idx]
+
+		default:
+			return errMaskPath
+		}
+	}
+
+	return nil
+}
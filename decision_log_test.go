@@ -0,0 +1,199 @@
+package runtime_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/plugins"
+	"github.com/open-policy-agent/opa/v1/plugins/logs"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const maskPolicy = `package system.log
+
+import rego.v1
+
+mask contains "/input/password"
+
+mask contains {"op": "upsert", "path": "/input/user", "value": "***"} if input.input.user
+`
+
+func TestWriterDecisionLogger(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	var buf bytes.Buffer
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+		Config: runtime.OPAConfig{
+			Labels: map[string]string{"env": "test"},
+		},
+	}, runtime.WithDecisionLogger(runtime.NewWriterDecisionLogger(&buf)))
+	assert.NoError(err)
+
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "mask.rego", []byte(maskPolicy))
+	})
+	assert.NoError(err)
+
+	input := map[string]any{"user": "alice", "password": "secret"}
+
+	// Act
+	result, err := r.Query(ctx, "data.simple.allowed", input, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	compileResult, err := r.Compile(ctx, "data.simple.allowed", input, nil, nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Assert
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(lines, 2)
+
+	var event map[string]any
+	assert.NoError(json.Unmarshal(lines[0], &event))
+
+	assert.Equal(result.DecisionID, event["decision_id"])
+	assert.Equal("data.simple.allowed", event["query"])
+	assert.Equal(map[string]any{"user": "***"}, event["input"])
+	assert.Equal([]any{"/input/password"}, event["erased"])
+	assert.Equal([]any{"/input/user"}, event["masked"])
+	assert.Subset(event["labels"], map[string]any{"env": "test"})
+	assert.NotEmpty(event["bundles"])
+	assert.NotEmpty(event["metrics"])
+
+	// the caller's input is not modified by masking.
+	assert.Equal("secret", input["password"])
+
+	assert.NoError(json.Unmarshal(lines[1], &event))
+	assert.Equal(compileResult.DecisionID, event["decision_id"])
+	assert.Equal(map[string]any{"user": "***"}, event["input"])
+}
+
+const testLogsPluginName = "test_decision_log"
+
+type testLogsPlugin struct {
+	events []logs.EventV1
+}
+
+func (p *testLogsPlugin) Start(context.Context) error      { return nil }
+func (p *testLogsPlugin) Stop(context.Context)             {}
+func (p *testLogsPlugin) Reconfigure(context.Context, any) {}
+
+func (p *testLogsPlugin) Log(_ context.Context, event logs.EventV1) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+type testLogsPluginFactory struct {
+	plugin *testLogsPlugin
+}
+
+func (f *testLogsPluginFactory) Validate(*plugins.Manager, []byte) (any, error) {
+	return struct{}{}, nil
+}
+
+func (f *testLogsPluginFactory) New(*plugins.Manager, any) plugins.Plugin {
+	return f.plugin
+}
+
+func TestDecisionLogsPlugin(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	pluginName := testLogsPluginName
+	factory := &testLogsPluginFactory{plugin: &testLogsPlugin{}}
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+		Config: runtime.OPAConfig{
+			DecisionLogs: &logs.Config{Plugin: &pluginName},
+			Plugins:      map[string]any{testLogsPluginName: struct{}{}},
+		},
+	}, runtime.WithPlugin(testLogsPluginName, factory))
+	assert.NoError(err)
+
+	// Act
+	result, err := r.Query(ctx, "data.simple.allowed", map[string]any{"user": "alice"}, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(factory.plugin.events, 1)
+
+	event := factory.plugin.events[0]
+	assert.Equal(result.DecisionID, event.DecisionID)
+	assert.Equal("data.simple.allowed", event.Query)
+	assert.NotNil(event.Input)
+	assert.NotNil(event.Result)
+}
+
+func TestWriterDecisionLoggerMaskChange(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	var buf bytes.Buffer
+
+	r, err := runtime.New(ctx, &runtime.Config{}, runtime.WithDecisionLogger(runtime.NewWriterDecisionLogger(&buf)))
+	assert.NoError(err)
+
+	input := map[string]any{"user": "alice", "password": "secret"}
+
+	// Act
+	_, err = r.Query(ctx, "x = 1", input, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "mask.rego", []byte(maskPolicy))
+	})
+	assert.NoError(err)
+
+	_, err = r.Query(ctx, "x = 1", input, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Assert
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	assert.Len(lines, 2)
+
+	var before, after map[string]any
+	assert.NoError(json.Unmarshal(lines[0], &before))
+	assert.NoError(json.Unmarshal(lines[1], &after))
+
+	assert.Equal(input, before["input"])
+	assert.Equal(map[string]any{"user": "***"}, after["input"])
+}
+
+func TestInternalQueriesNotLogged(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	var buf bytes.Buffer
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	}, runtime.WithDecisionLogger(runtime.NewWriterDecisionLogger(&buf)))
+	assert.NoError(err)
+
+	// Act
+	bundles, err := r.GetBundles(ctx)
+
+	// Assert
+	assert.NoError(err)
+	assert.NotEmpty(bundles)
+	assert.Empty(buf.String())
+}
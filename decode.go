@@ -0,0 +1,100 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/pkg/errors"
+)
+
+// DecodeError is returned when the value produced by a query cannot be decoded into the requested type.
+type DecodeError struct {
+	Query string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode result of query [%s]: %s", e.Query, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// EvalRule evaluates the rule referenced by rule (e.g. "data.mycars.GET.cars.allowed") and
+// decodes its value into T using JSON struct tags.
+// It returns ErrUndefined if the rule is undefined for the given input, and a *DecodeError
+// if the value does not match T.
+func EvalRule[T any](ctx context.Context, r *Runtime, rule string, input map[string]any) (T, error) {
+	var zero T
+
+	ref, err := ast.ParseRef(rule)
+	if err != nil {
+		return zero, errors.Wrapf(err, "invalid rule reference [%s]", rule)
+	}
+
+	if !ref.IsGround() {
+		return zero, errors.Errorf("rule reference [%s] must not contain variables", rule)
+	}
+
+	result, err := r.Query(ctx, ref.String(), input, false, false, false, types.ExplainOffV1)
+	if err != nil {
+		return zero, err
+	}
+
+	if len(result.Result) == 0 || len(result.Result[0].Expressions) == 0 {
+		return zero, errors.Wrapf(ErrUndefined, "rule [%s]", rule)
+	}
+
+	return decodeValue[T](rule, result.Result[0].Expressions[0].Value)
+}
+
+// QueryInto evaluates query and decodes the value bound to binding in the first result into T.
+// If binding is empty, the value of the first expression of the query is decoded instead.
+// It returns ErrUndefined if the query has no results or the binding is missing, and a
+// *DecodeError if the value does not match T.
+func QueryInto[T any](ctx context.Context, r *Runtime, query, binding string, input map[string]any) (T, error) {
+	var zero T
+
+	result, err := r.Query(ctx, query, input, false, false, false, types.ExplainOffV1)
+	if err != nil {
+		return zero, err
+	}
+
+	if len(result.Result) == 0 {
+		return zero, errors.Wrapf(ErrUndefined, "query [%s]", query)
+	}
+
+	if binding == "" {
+		if len(result.Result[0].Expressions) == 0 {
+			return zero, errors.Wrapf(ErrUndefined, "query [%s]", query)
+		}
+
+		return decodeValue[T](query, result.Result[0].Expressions[0].Value)
+	}
+
+	value, ok := result.Result[0].Bindings[binding]
+	if !ok {
+		return zero, errors.Wrapf(ErrUndefined, "binding [%s] in query [%s]", binding, query)
+	}
+
+	return decodeValue[T](query, value)
+}
+
+func decodeValue[T any](query string, value any) (T, error) {
+	var out T
+
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return out, &DecodeError{Query: query, Err: err}
+	}
+
+	if err := json.Unmarshal(buf, &out); err != nil {
+		return out, &DecodeError{Query: query, Err: err}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,65 @@
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEvalRule(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	// Act
+	allowed, err := runtime.EvalRule[bool](ctx, r, "data.simple.allowed", nil)
+
+	// Assert
+	assert.NoError(err)
+	assert.False(allowed)
+
+	_, err = runtime.EvalRule[bool](ctx, r, "data.simple.missing", nil)
+	assert.ErrorIs(err, runtime.ErrUndefined)
+
+	_, err = runtime.EvalRule[string](ctx, r, "data.simple.allowed", nil)
+
+	var decodeErr *runtime.DecodeError
+	assert.ErrorAs(err, &decodeErr)
+	assert.NotErrorIs(err, runtime.ErrUndefined)
+}
+
+func TestQueryInto(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{})
+	assert.NoError(err)
+
+	type decision struct {
+		Allowed bool   `json:"allowed"`
+		Reason  string `json:"reason"`
+		Count   int    `json:"count"`
+	}
+
+	input := map[string]any{"user": "alice"}
+
+	// Act
+	d, err := runtime.QueryInto[decision](ctx, r, `x = {"allowed": input.user == "alice", "reason": "ok", "count": 2}`, "x", input)
+
+	// Assert
+	assert.NoError(err)
+	assert.Equal(decision{Allowed: true, Reason: "ok", Count: 2}, d)
+
+	_, err = runtime.QueryInto[decision](ctx, r, `x = input.missing`, "x", input)
+	assert.ErrorIs(err, runtime.ErrUndefined)
+}
@@ -0,0 +1,149 @@
+package runtime
+
+import (
+	"fmt"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/pkg/errors"
+)
+
+// Errors returned by the runtime can be matched using errors.Is, and their details retrieved using errors.As
+// with the typed errors below.
+var (
+	// ErrNotFound is matched by *NotFoundError.
+	ErrNotFound = errors.New("not found")
+	// ErrParse is matched by *ParseError.
+	ErrParse = errors.New("parse error")
+	// ErrCompile is matched by *CompileError.
+	ErrCompile = errors.New("compile error")
+	// ErrEval is matched by *EvalError.
+	ErrEval = errors.New("evaluation error")
+	// ErrCancelled is matched by evaluation errors caused by the cancellation of the caller's context.
+	ErrCancelled = errors.New("evaluation cancelled")
+	// ErrRuntimeNotReady is returned by WaitForPlugins when the runtime fails to become ready.
+	ErrRuntimeNotReady = errors.New("runtime not ready")
+	// ErrUndefined is returned when a query or rule produces no result.
+	ErrUndefined = errors.New("undefined result")
+	// ErrInvalidInput is matched by all errors caused by input not matching its entrypoint's schema.
+	ErrInvalidInput = errors.New("invalid input")
+	// ErrEvalBudgetExceeded is matched by all errors caused by an evaluation running out of budget.
+	ErrEvalBudgetExceeded = errors.New("evaluation budget exceeded")
+	// ErrOverloaded is matched by *OverloadError.
+	ErrOverloaded = errors.New("runtime overloaded")
+	// ErrNotWasmEntrypoint is returned in wasm evaluation mode for queries that are not compiled entrypoints.
+	ErrNotWasmEntrypoint = errors.New("query is not a compiled wasm entrypoint")
+	// ErrWasmUnsupported is returned by Compile in wasm evaluation mode.
+	ErrWasmUnsupported = errors.New("not supported in wasm evaluation mode")
+)
+
+// NotFoundError is returned when a bundle or policy does not exist.
+type NotFoundError struct {
+	// Kind is the kind of the missing object, e.g. "policy" or "bundle".
+	Kind string
+	ID   string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s not found [%s]", e.Kind, e.ID)
+}
+
+func (e *NotFoundError) Is(target error) bool {
+	return target == ErrNotFound
+}
+
+// ParseError is returned when a query or policy cannot be parsed. Each error holds its location.
+type ParseError struct {
+	Errors ast.Errors
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrParse, e.Errors)
+}
+
+func (e *ParseError) Is(target error) bool {
+	return target == ErrParse
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Errors
+}
+
+// CompileError is returned when a query or policy fails to compile, e.g. because of a type error
+// or a call to an unsafe builtin. Each error holds its location.
+type CompileError struct {
+	Errors ast.Errors
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrCompile, e.Errors)
+}
+
+func (e *CompileError) Is(target error) bool {
+	return target == ErrCompile
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Errors
+}
+
+// EvalError is returned when the evaluation of a query fails. Its cause is one of the other errors
+// of the package (e.g. a *CompileError or ErrCancelled), or a topdown error. Input rejected before
+// the evaluation is reported by an *InputValidationError instead.
+type EvalError struct {
+	DecisionID string
+	Query      string
+	Err        error
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("query execution failed, decision-id: [%s], query: [%s]: %s", e.DecisionID, e.Query, e.Err)
+}
+
+func (e *EvalError) Is(target error) bool {
+	return target == ErrEval
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// evalError wraps the error of an evaluation in an *EvalError, unless its input was rejected.
+func evalError(decisionID, query string, err error) error {
+	var validationErr *InputValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr
+	}
+
+	return &EvalError{DecisionID: decisionID, Query: query, Err: err}
+}
+
+// parseError converts the errors returned by the parser to a *ParseError.
+func parseError(err error) error {
+	var astErrs ast.Errors
+	if errors.As(err, &astErrs) {
+		return &ParseError{Errors: astErrs}
+	}
+
+	return err
+}
+
+// compileError converts the errors returned by the compiler to a *CompileError.
+func compileError(err error) error {
+	var astErrs ast.Errors
+	if errors.As(err, &astErrs) {
+		return &CompileError{Errors: astErrs}
+	}
+
+	return err
+}
+
+// cancelError marks topdown cancellation errors with ErrCancelled. Cancellations caused by
+// an evaluation budget are reported separately, see evalBudget.err.
+func cancelError(err error) error {
+	if topdown.IsCancel(err) {
+		return fmt.Errorf("%w: %w", ErrCancelled, err)
+	}
+
+	return err
+}
@@ -0,0 +1,108 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryErrors(t *testing.T) {
+	r, err := runtime.New(t.Context(), &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	require.NoError(t, err)
+
+	cancelled, cancel := context.WithCancel(t.Context())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context //nolint:containedctx
+		query  string
+		target error
+		eval   bool
+	}{
+		{"parse error", t.Context(), "x = data.simple[", runtime.ErrParse, false},
+		{"compile error", t.Context(), "x = undefined_function(1)", runtime.ErrCompile, true},
+		{"cancelled", cancelled, "x = count([y | some y in numbers.range(1, 100000)])", runtime.ErrCancelled, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			assert := require.New(t)
+
+			// Act
+			_, err := r.Query(tt.ctx, tt.query, nil, false, false, false, types.ExplainOffV1)
+
+			// Assert
+			assert.ErrorIs(err, tt.target)
+
+			var evalErr *runtime.EvalError
+			assert.Equal(tt.eval, errors.As(err, &evalErr))
+
+			if tt.eval {
+				assert.ErrorIs(err, runtime.ErrEval)
+				assert.NotEmpty(evalErr.DecisionID)
+				assert.Equal(tt.query, evalErr.Query)
+			}
+		})
+	}
+}
+
+func TestLocatedErrors(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+
+	r, err := runtime.New(t.Context(), &runtime.Config{})
+	assert.NoError(err)
+
+	// Act
+	_, parseErr := r.ValidateQuery("x = [1,")
+	_, compileErr := r.Compile(t.Context(), "x = undefined_function(1)", nil, nil, nil, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	var pe *runtime.ParseError
+	assert.ErrorAs(parseErr, &pe)
+	assert.NotEmpty(pe.Errors)
+	assert.NotNil(pe.Errors[0].Location)
+
+	var ce *runtime.CompileError
+	assert.ErrorAs(compileErr, &ce)
+	assert.NotEmpty(ce.Errors)
+	assert.NotNil(ce.Errors[0].Location)
+}
+
+func TestNotFoundErrors(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	// Act
+	_, policyErr := r.GetPolicy(ctx, "missing.rego")
+	_, moduleErr := r.GetModule(ctx, "bWlzc2luZy5yZWdv")
+	_, bundleErr := r.GetBundleByID(ctx, "missing")
+
+	// Assert
+	var nf *runtime.NotFoundError
+
+	assert.ErrorIs(policyErr, runtime.ErrNotFound)
+	assert.ErrorIs(moduleErr, runtime.ErrNotFound)
+	assert.ErrorAs(bundleErr, &nf)
+	assert.Equal("bundle", nf.Kind)
+	assert.Equal("missing", nf.ID)
+}
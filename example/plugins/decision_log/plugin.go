@@ -4,9 +4,9 @@ import (
 	"context"
 	"io"
 	"os"
-	"time"
 
 	"github.com/open-policy-agent/opa/v1/plugins"
+	"github.com/open-policy-agent/opa/v1/plugins/logs"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 )
@@ -55,15 +55,13 @@ func (dl *DecisionLogger) Stop(ctx context.Context) {
 func (dl *DecisionLogger) Reconfigure(ctx context.Context, config any) {
 }
 
-type Event struct {
-	DecisionID string
-	Timestamp  time.Time
-}
-
-func (dl *DecisionLogger) Log(ctx context.Context, event *Event) error {
+// Log implements logs.Logger, so the plugin can be used as the decision_logs plugin.
+func (dl *DecisionLogger) Log(ctx context.Context, event logs.EventV1) error {
 	dl.logger.Log().
 		Str("decision_id", event.DecisionID).
 		Time("decision_time", event.Timestamp).
+		Str("query", event.Query).
+		Interface("result", event.Result).
 		Send()
 
 	return nil
@@ -8,6 +8,7 @@ import (
 	runtime "github.com/aserto-dev/runtime"
 	"github.com/aserto-dev/runtime/example/plugins/decision_log"
 	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/plugins/logs"
 	"github.com/open-policy-agent/opa/v1/rego"
 	"github.com/open-policy-agent/opa/v1/types"
 	"github.com/pkg/errors"
@@ -24,12 +25,16 @@ type QueryXCmd struct {
 
 func (c *QueryXCmd) Run() error {
 	ctx := setupLoggerAndContext(c.Verbosity)
+	decisionLogPlugin := decision_log.PluginName
 
 	r, err := runtime.New(ctx, &runtime.Config{
 		LocalBundles: runtime.LocalBundlesConfig{
 			Paths: []string{c.Policy},
 		},
 		Config: runtime.OPAConfig{
+			DecisionLogs: &logs.Config{
+				Plugin: &decisionLogPlugin,
+			},
 			Plugins: map[string]any{
 				decision_log.PluginName: decision_log.Config{
 					Enabled: true,
@@ -81,18 +86,6 @@ func (c *QueryXCmd) Run() error {
 		return errors.Wrap(err, "query error")
 	}
 
-	decisionLogger, err := decision_log.Lookup(r.GetPluginsManager())
-	if err != nil {
-		return errors.Wrap(err, "decision logger lookup failed")
-	}
-
-	if err := decisionLogger.Log(ctx, &decision_log.Event{
-		DecisionID: result.DecisionID,
-		Timestamp:  time.Now().UTC(),
-	}); err != nil {
-		return errors.Wrap(err, "failed to log decision")
-	}
-
 	out, err := json.MarshalIndent(result.Result, "", "  ")
 	if err != nil {
 		return errors.Wrap(err, "can't marshal output json")
@@ -0,0 +1,56 @@
+package runtime
+
+import (
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/open-policy-agent/opa/v1/topdown/lineage"
+)
+
+// RenderExplanation renders the explanation of the result as indented text, as printed by "opa eval --explain",
+// with the location (file:row) of every step. It is empty unless the query was evaluated with an explain mode
+// or WithExplanationText.
+func (r *Result) RenderExplanation() string {
+	if r.explainMode == types.ExplainOffV1 {
+		return renderTrace(r.trace)
+	}
+
+	return renderTrace(explainTrace(r.explainMode, r.trace))
+}
+
+// RenderFailures renders the failed expressions of the evaluation, along with the steps that led to them.
+// It is useful to understand why a query is false or undefined, see RenderExplanation.
+func (r *Result) RenderFailures() string {
+	return renderTrace(lineage.Fails(r.trace))
+}
+
+func renderTrace(trace []*topdown.Event) string {
+	if len(trace) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+
+	topdown.PrettyTraceWithLocation(&sb, trace)
+
+	return sb.String()
+}
+
+// explainTrace filters a trace according to the explain mode.
+func explainTrace(explainMode types.ExplainModeV1, trace []*topdown.Event) []*topdown.Event {
+	switch explainMode {
+	case types.ExplainNotesV1:
+		return lineage.Notes(trace)
+	case types.ExplainFailsV1:
+		return lineage.Fails(trace)
+	case types.ExplainDebugV1:
+		return lineage.Debug(trace)
+	case types.ExplainFullV1:
+		return trace
+	case types.ExplainOffV1:
+		return nil
+	}
+
+	return nil
+}
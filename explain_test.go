@@ -0,0 +1,57 @@
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExplanationText(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	query := `data.simple.allowed == false; input.user == "alice"`
+	input := map[string]any{"user": "bob"}
+
+	// Act
+	full, err := r.Query(ctx, query, input, false, false, false, types.ExplainOffV1, runtime.WithExplanationText())
+	assert.NoError(err)
+
+	fails, err := r.Query(ctx, query, input, false, false, false, types.ExplainFailsV1, runtime.WithExplanationText())
+	assert.NoError(err)
+
+	explained, err := r.Query(ctx, query, input, false, false, false, types.ExplainFullV1)
+	assert.NoError(err)
+
+	off, err := r.Query(ctx, query, input, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Assert
+	assert.Empty(full.Result)
+	assert.Nil(full.Explanation)
+	assert.Contains(full.ExplanationText, "hello.rego:")
+	assert.Contains(full.ExplanationText, "query:1")
+	assert.Contains(full.ExplanationText, "| Fail ")
+
+	assert.NotNil(fails.Explanation)
+	assert.Contains(fails.ExplanationText, "| Fail ")
+	assert.NotContains(fails.ExplanationText, "hello.rego:")
+
+	assert.Empty(explained.ExplanationText)
+	assert.Equal(full.ExplanationText, explained.RenderExplanation())
+	assert.Equal(fails.ExplanationText, explained.RenderFailures())
+
+	assert.Empty(off.RenderExplanation())
+	assert.Empty(off.RenderFailures())
+}
@@ -0,0 +1,234 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/open-policy-agent/opa/v1/util"
+	"github.com/pkg/errors"
+)
+
+// InputViolation describes a single way in which the input does not match the schema.
+type InputViolation struct {
+	Field       string `json:"field"`
+	Type        string `json:"type"`
+	Description string `json:"desc"`
+}
+
+// InputValidationError is returned by Query when the input does not match the schema of the queried entrypoint.
+type InputValidationError struct {
+	DecisionID string
+	Entrypoint string
+	Violations []InputViolation
+}
+
+func (e *InputValidationError) Error() string {
+	violations := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		violations[i] = v.Field + ": " + v.Description
+	}
+
+	return fmt.Sprintf("%s for [%s]: %s, decision-id: [%s]", ErrInvalidInput, e.Entrypoint, strings.Join(violations, "; "), e.DecisionID)
+}
+
+func (e *InputValidationError) Is(target error) bool {
+	return target == ErrInvalidInput
+}
+
+// entrypointSchemaEntry caches the schema annotation of an entrypoint for a given compiler.
+type entrypointSchemaEntry struct {
+	compiler *ast.Compiler
+	schema   ast.Value
+}
+
+// setupInputSchemas converts the schemas registered with WithInputSchema to AST values.
+func (r *Runtime) setupInputSchemas() error {
+	for entrypoint, schema := range r.inputSchemaDefs {
+		ref, err := ast.ParseRef(entrypoint)
+		if err != nil {
+			return errors.Wrapf(err, "invalid entrypoint [%s]", entrypoint)
+		}
+
+		value, err := schemaValue(schema)
+		if err != nil {
+			return errors.Wrapf(err, "invalid input schema for [%s]", entrypoint)
+		}
+
+		r.inputSchemas[ref.String()] = value
+	}
+
+	return nil
+}
+
+// schemaValue converts a schema, given as a JSON document or a Go value, to an AST value.
+func schemaValue(schema any) (ast.Value, error) {
+	switch s := schema.(type) {
+	case string:
+		return schemaValue([]byte(s))
+	case json.RawMessage:
+		return schemaValue([]byte(s))
+	case []byte:
+		var v any
+		if err := util.UnmarshalJSON(s, &v); err != nil {
+			return nil, err
+		}
+
+		return ast.InterfaceToValue(v)
+	}
+
+	return ast.InterfaceToValue(schema)
+}
+
+// queryEntrypoint returns the rule queried by a query of the form "data.x.y", "x = data.x.y", "x := data.x.y"
+// or "data.x.y = x", if any.
+func queryEntrypoint(query ast.Body) (ast.Ref, bool) {
+	if len(query) != 1 {
+		return nil, false
+	}
+
+	var term *ast.Term
+
+	switch expr := query[0]; {
+	case expr.IsAssignment():
+		term = expr.Operand(1)
+	case expr.IsEquality():
+		term = expr.Operand(1)
+		if _, ok := expr.Operand(0).Value.(ast.Ref); ok {
+			term = expr.Operand(0)
+		}
+	case !expr.IsCall():
+		term, _ = expr.Terms.(*ast.Term)
+	}
+
+	if term == nil {
+		return nil, false
+	}
+
+	ref, ok := term.Value.(ast.Ref)
+	if !ok || !ref.HasPrefix(ast.DefaultRootRef) || !ref.IsGround() {
+		return nil, false
+	}
+
+	return ref, true
+}
+
+// inputSchema returns the input schema of an entrypoint. Schemas registered with WithInputSchema take precedence
+// over inline schemas from "# METADATA" annotations, e.g.:
+//
+//	# METADATA
+//	# schemas:
+//	#   - input: {"type": "object", "required": ["user"]}
+//
+// Annotations referring to schema documents (e.g. "input: schema.user") are only used by the compiler's type checker.
+func (r *Runtime) inputSchema(entrypoint ast.Ref) ast.Value {
+	key := entrypoint.String()
+	if schema, ok := r.inputSchemas[key]; ok {
+		return schema
+	}
+
+	compiler := r.pluginsManager.GetCompiler()
+	if compiler == nil || !r.annotatedSchemas {
+		return nil
+	}
+
+	if entry, ok := r.entrypointSchemas.Load(key); ok && entry.(*entrypointSchemaEntry).compiler == compiler {
+		return entry.(*entrypointSchemaEntry).schema
+	}
+
+	schema := annotatedInputSchema(compiler, entrypoint)
+	r.entrypointSchemas.Store(key, &entrypointSchemaEntry{compiler: compiler, schema: schema})
+
+	return schema
+}
+
+func annotatedInputSchema(compiler *ast.Compiler, entrypoint ast.Ref) ast.Value {
+	as := compiler.GetAnnotationSet()
+	if as == nil {
+		return nil
+	}
+
+	for _, rule := range compiler.GetRulesExact(entrypoint) {
+		// the chain is ordered from the most to the least specific annotation.
+		for _, ref := range as.Chain(rule) {
+			if ref.Annotations == nil {
+				continue
+			}
+
+			for _, s := range ref.Annotations.Schemas {
+				if !s.Path.Equal(ast.InputRootRef) || s.Definition == nil {
+					continue
+				}
+
+				if value, err := ast.InterfaceToValue(*s.Definition); err == nil {
+					return value
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateInput checks the input against the schema of the queried entrypoint, if it has one.
+func (r *Runtime) validateInput(ctx context.Context, decisionID string, query ast.Body, input map[string]any) error {
+	entrypoint, ok := queryEntrypoint(query)
+	if !ok {
+		return nil
+	}
+
+	schema := r.inputSchema(entrypoint)
+	if schema == nil {
+		return nil
+	}
+
+	var inputValue ast.Value = ast.Null{}
+
+	if input != nil {
+		v, err := ast.InterfaceToValue(input)
+		if err != nil {
+			return errors.Wrap(err, "failed to convert input")
+		}
+
+		inputValue = v
+	}
+
+	bctx := topdown.BuiltinContext{
+		Context:                     ctx,
+		InterQueryBuiltinValueCache: r.schemaCache,
+	}
+
+	var result *ast.Term
+
+	matchSchema := topdown.GetBuiltin(ast.JSONMatchSchema.Name)
+	if err := matchSchema(bctx, []*ast.Term{ast.NewTerm(inputValue), ast.NewTerm(schema)}, func(t *ast.Term) error {
+		result = t
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "failed to validate input for [%s]", entrypoint)
+	}
+
+	var match []json.RawMessage
+	if err := ast.As(result.Value, &match); err != nil || len(match) != 2 {
+		return errors.Errorf("unexpected schema validation result %v", result)
+	}
+
+	var valid bool
+	if err := json.Unmarshal(match[0], &valid); err != nil {
+		return errors.Wrap(err, "failed to decode schema validation result")
+	}
+
+	if valid {
+		return nil
+	}
+
+	validationErr := &InputValidationError{DecisionID: decisionID, Entrypoint: entrypoint.String()}
+	if err := json.Unmarshal(match[1], &validationErr.Violations); err != nil {
+		return errors.Wrap(err, "failed to decode schema violations")
+	}
+
+	return validationErr
+}
@@ -0,0 +1,115 @@
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInputSchema(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		input      map[string]any
+		violations []string
+	}{
+		{
+			name:  "annotated entrypoint, valid input",
+			query: "data.schema.allowed",
+			input: map[string]any{"user": "alice"},
+		},
+		{
+			name:       "annotated entrypoint, invalid input",
+			query:      "x = data.schema.allowed",
+			input:      map[string]any{"usr": "alice"},
+			violations: []string{"(Root)"},
+		},
+		{
+			name:       "annotated entrypoint, reversed unification",
+			query:      "data.schema.allowed = x",
+			input:      map[string]any{"usr": "alice"},
+			violations: []string{"(Root)"},
+		},
+		{
+			name:       "annotated entrypoint, assignment",
+			query:      "x := data.schema.allowed",
+			input:      map[string]any{"usr": "alice"},
+			violations: []string{"(Root)"},
+		},
+		{
+			name:       "option entrypoint, invalid input",
+			query:      "data.schema.denied",
+			input:      map[string]any{"user": 1},
+			violations: []string{"user"},
+		},
+		{
+			name:  "not an entrypoint",
+			query: "x = input.usr",
+			input: map[string]any{"usr": "alice"},
+		},
+	}
+
+	// Arrange
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSchemaBundle()},
+		},
+	}, runtime.WithInputSchema("data.schema.denied", `{"properties": {"user": {"type": "string"}}}`),
+		runtime.WithAnnotatedInputSchemas())
+	require.NoError(t, err)
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert := require.New(t)
+
+			// Act
+			result, err := r.Query(ctx, tc.query, tc.input, false, false, false, types.ExplainOffV1)
+
+			// Assert
+			if tc.violations == nil {
+				assert.NoError(err)
+				assert.NotEmpty(result.Result)
+
+				return
+			}
+
+			assert.ErrorIs(err, runtime.ErrInvalidInput)
+			assert.NotErrorIs(err, runtime.ErrEval)
+
+			var validationErr *runtime.InputValidationError
+			assert.ErrorAs(err, &validationErr)
+
+			fields := []string{}
+			for _, v := range validationErr.Violations {
+				fields = append(fields, v.Field)
+			}
+
+			assert.Equal(tc.violations, fields)
+		})
+	}
+}
+
+func TestInputSchemaAnnotationsDisabled(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSchemaBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	// Act
+	result, err := r.Query(ctx, "x = data.schema.allowed", map[string]any{"usr": "alice"}, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	assert.NoError(err)
+	assert.Empty(result.Result)
+}
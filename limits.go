@@ -0,0 +1,159 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/pkg/errors"
+)
+
+// EvalBudget names a resource limited by EvalLimits.
+type EvalBudget string
+
+const (
+	BudgetTimeout   EvalBudget = "timeout"
+	BudgetInputSize EvalBudget = "input_size"
+	BudgetSteps     EvalBudget = "steps"
+)
+
+// EvalLimits bounds the resources a single evaluation may use. A zero value disables the corresponding limit.
+type EvalLimits struct {
+	// Timeout is the maximum wall-clock duration of an evaluation.
+	Timeout time.Duration
+	// MaxInputSize is the maximum size of the JSON-encoded input, in bytes.
+	MaxInputSize int
+	// MaxSteps is the maximum number of evaluation steps (trace events) an evaluation may take.
+	// Enabling it attaches a tracer to the evaluation, which adds some overhead.
+	MaxSteps uint64
+}
+
+// EvalBudgetError is returned when an evaluation exceeds one of its EvalLimits.
+type EvalBudgetError struct {
+	DecisionID string
+	Budget     EvalBudget
+	Limit      string
+}
+
+func (e *EvalBudgetError) Error() string {
+	return fmt.Sprintf("%s: %s limit of %s reached, decision-id: [%s]", ErrEvalBudgetExceeded, e.Budget, e.Limit, e.DecisionID)
+}
+
+func (e *EvalBudgetError) Is(target error) bool {
+	return target == ErrEvalBudgetExceeded
+}
+
+// override returns l with all non-zero limits from o applied on top.
+func (l EvalLimits) override(o EvalLimits) EvalLimits {
+	if o.Timeout > 0 {
+		l.Timeout = o.Timeout
+	}
+
+	if o.MaxInputSize > 0 {
+		l.MaxInputSize = o.MaxInputSize
+	}
+
+	if o.MaxSteps > 0 {
+		l.MaxSteps = o.MaxSteps
+	}
+
+	return l
+}
+
+func (l EvalLimits) checkInput(decisionID string, input map[string]any) error {
+	if l.MaxInputSize <= 0 || input == nil {
+		return nil
+	}
+
+	buf, err := json.Marshal(input)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode input")
+	}
+
+	if len(buf) > l.MaxInputSize {
+		return &EvalBudgetError{DecisionID: decisionID, Budget: BudgetInputSize, Limit: fmt.Sprintf("%d bytes", l.MaxInputSize)}
+	}
+
+	return nil
+}
+
+// cancellation causes set on an evaluation context when a budget runs out.
+var (
+	errTimeoutBudget = errors.New("evaluation timed out")
+	errStepsBudget   = errors.New("evaluation step limit reached")
+)
+
+// evalBudget enforces the timeout and step limits of a single evaluation by cancelling its context.
+type evalBudget struct {
+	limits EvalLimits
+	ctx    context.Context //nolint:containedctx
+	cancel context.CancelCauseFunc
+	stop   context.CancelFunc
+	steps  *stepLimiter
+}
+
+func newEvalBudget(ctx context.Context, limits EvalLimits) *evalBudget {
+	b := &evalBudget{limits: limits, stop: func() {}}
+
+	b.ctx, b.cancel = context.WithCancelCause(ctx)
+
+	if limits.Timeout > 0 {
+		b.ctx, b.stop = context.WithTimeoutCause(b.ctx, limits.Timeout, errTimeoutBudget)
+	}
+
+	if limits.MaxSteps > 0 {
+		b.steps = &stepLimiter{max: limits.MaxSteps, cancel: b.cancel}
+	}
+
+	return b
+}
+
+// tracers returns the query tracers required to enforce the budget.
+func (b *evalBudget) tracers() []topdown.QueryTracer {
+	if b.steps == nil {
+		return nil
+	}
+
+	return []topdown.QueryTracer{b.steps}
+}
+
+// err returns an *EvalBudgetError if the evaluation was cancelled because it ran out of budget.
+func (b *evalBudget) err(decisionID string) error {
+	switch cause := context.Cause(b.ctx); {
+	case errors.Is(cause, errTimeoutBudget):
+		return &EvalBudgetError{DecisionID: decisionID, Budget: BudgetTimeout, Limit: b.limits.Timeout.String()}
+	case errors.Is(cause, errStepsBudget):
+		return &EvalBudgetError{DecisionID: decisionID, Budget: BudgetSteps, Limit: fmt.Sprintf("%d steps", b.limits.MaxSteps)}
+	default:
+		return nil
+	}
+}
+
+func (b *evalBudget) release() {
+	b.stop()
+	b.cancel(nil)
+}
+
+// stepLimiter is a query tracer that cancels the evaluation once it has seen more than max events.
+type stepLimiter struct {
+	max    uint64
+	count  atomic.Uint64
+	cancel context.CancelCauseFunc
+}
+
+func (s *stepLimiter) Enabled() bool {
+	return true
+}
+
+func (s *stepLimiter) TraceEvent(topdown.Event) {
+	if s.count.Add(1) == s.max+1 {
+		s.cancel(errStepsBudget)
+	}
+}
+
+func (s *stepLimiter) Config() topdown.TraceConfig {
+	return topdown.TraceConfig{}
+}
@@ -0,0 +1,101 @@
+package runtime_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+const expensiveQuery = "x = count([y | some y in numbers.range(1, 100000); y % 7 == 0])"
+
+func TestEvalLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  runtime.EvalLimits
+		query   string
+		input   map[string]any
+		budget  runtime.EvalBudget
+		success bool
+	}{
+		{
+			name:   "steps",
+			limits: runtime.EvalLimits{MaxSteps: 1000},
+			query:  expensiveQuery,
+			budget: runtime.BudgetSteps,
+		},
+		{
+			name:   "timeout",
+			limits: runtime.EvalLimits{Timeout: time.Millisecond},
+			query:  expensiveQuery,
+			budget: runtime.BudgetTimeout,
+		},
+		{
+			name:   "input size",
+			limits: runtime.EvalLimits{MaxInputSize: 64},
+			query:  "x = input.data",
+			input:  map[string]any{"data": strings.Repeat("a", 128)},
+			budget: runtime.BudgetInputSize,
+		},
+		{
+			name:    "within limits",
+			limits:  runtime.EvalLimits{MaxSteps: 1000, MaxInputSize: 64, Timeout: time.Minute},
+			query:   "x = input.data",
+			input:   map[string]any{"data": "a"},
+			success: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Arrange
+			assert := require.New(t)
+			ctx := t.Context()
+
+			r, err := runtime.New(ctx, &runtime.Config{})
+			assert.NoError(err)
+
+			// Act
+			result, err := r.Query(ctx, tc.query, tc.input, false, false, false, types.ExplainOffV1, runtime.WithQueryLimits(tc.limits))
+
+			// Assert
+			if tc.success {
+				assert.NoError(err)
+				assert.Len(result.Result, 1)
+
+				return
+			}
+
+			assert.ErrorIs(err, runtime.ErrEvalBudgetExceeded)
+
+			var budgetErr *runtime.EvalBudgetError
+			assert.ErrorAs(err, &budgetErr)
+			assert.Equal(tc.budget, budgetErr.Budget)
+			assert.NotEmpty(budgetErr.DecisionID)
+		})
+	}
+}
+
+func TestRuntimeEvalLimits(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{}, runtime.WithEvalLimits(runtime.EvalLimits{MaxSteps: 1000}))
+	assert.NoError(err)
+
+	// Act
+	_, queryErr := r.Query(ctx, expensiveQuery, nil, false, false, false, types.ExplainOffV1)
+	_, compileErr := r.Compile(ctx, expensiveQuery, nil, nil, nil, false, false, false, types.ExplainOffV1)
+	_, overrideErr := r.Query(ctx, "x = 1", nil, false, false, false, types.ExplainOffV1,
+		runtime.WithQueryLimits(runtime.EvalLimits{MaxSteps: 1_000_000}),
+	)
+
+	// Assert
+	assert.ErrorIs(queryErr, runtime.ErrEvalBudgetExceeded)
+	assert.ErrorIs(compileErr, runtime.ErrEvalBudgetExceeded)
+	assert.NoError(overrideErr)
+}
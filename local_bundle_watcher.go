@@ -14,6 +14,7 @@ import (
 	"github.com/open-policy-agent/opa/v1/storage"
 	"github.com/open-policy-agent/opa/v1/version"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 func (r *Runtime) onReloadLogger(d time.Duration, err error) {
@@ -95,7 +96,11 @@ func (r *Runtime) readWatcher(ctx context.Context, watcher *fsnotify.Watcher, pa
 				removed = evt.Name
 			}
 
-			err := r.processWatcherUpdate(ctx, paths, removed)
+			spanCtx, span := r.startSpan(ctx, "runtime.WatcherReload", attribute.String(attrPath, evt.Name))
+			err := spanError(span, r.processWatcherUpdate(spanCtx, paths, removed))
+			span.End()
+
+			r.metrics.observeReload(time.Since(t0), err)
 			onReload(time.Since(t0), err)
 		}
 	}
@@ -108,7 +113,7 @@ func (r *Runtime) processWatcherUpdate(ctx context.Context, paths []string, remo
 		}
 	}
 
-	loadedBundles, err := r.loadPaths(paths)
+	loadedBundles, err := r.loadPaths(ctx, paths)
 	if err != nil {
 		return err
 	}
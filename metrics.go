@@ -0,0 +1,374 @@
+package runtime
+
+import (
+	"slices"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/plugins"
+	"github.com/open-policy-agent/opa/v1/topdown/cache"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "runtime"
+
+// pluginStates are the states reported by the plugin state gauge.
+var pluginStates = []plugins.State{plugins.StateNotReady, plugins.StateOK, plugins.StateErr, plugins.StateWarn}
+
+// runtimeMetrics holds the Prometheus collectors of a runtime, see WithPrometheus.
+// All methods are no-ops on a nil *runtimeMetrics.
+type runtimeMetrics struct {
+	queryDuration      *prometheus.HistogramVec
+	queryErrors        *prometheus.CounterVec
+	bundleActivation   *prometheus.HistogramVec
+	bundleActivations  *prometheus.CounterVec
+	bundleRevision     *prometheus.GaugeVec
+	pluginState        *prometheus.GaugeVec
+	watcherReload      prometheus.Histogram
+	watcherReloadError prometheus.Counter
+	interQueryCache    *prometheus.CounterVec
+	admissionWait      prometheus.Histogram
+	admissionRejected  *prometheus.CounterVec
+}
+
+// setupMetrics creates and registers the runtime's collectors with the registerer set by WithPrometheus.
+func (r *Runtime) setupMetrics() error {
+	if r.metricsRegisterer == nil {
+		return nil
+	}
+
+	m := &runtimeMetrics{}
+
+	collectors := slices.Concat(
+		r.setupQueryMetrics(m),
+		r.setupBundleMetrics(m),
+		r.setupWatcherMetrics(m),
+		r.setupCacheMetrics(m),
+		r.setupAdmissionMetrics(m),
+	)
+
+	for _, c := range collectors {
+		if err := r.metricsRegisterer.Register(c); err != nil {
+			return errors.Wrap(err, "failed to register runtime metrics")
+		}
+	}
+
+	r.metrics = m
+
+	return nil
+}
+
+// setupQueryMetrics creates the instruments of Query and Compile calls.
+func (r *Runtime) setupQueryMetrics(m *runtimeMetrics) []prometheus.Collector {
+	m.queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_duration_seconds",
+		Help:      "Duration of Query and Compile calls. The entrypoint is empty for queries that are not a rule reference.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	}, []string{"op", "entrypoint"})
+	m.queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "query_errors_total",
+		Help:      "Number of failed Query and Compile calls, by reason.",
+	}, []string{"op", "entrypoint", "reason"})
+
+	return []prometheus.Collector{m.queryDuration, m.queryErrors}
+}
+
+// setupBundleMetrics creates the instruments of bundle activations and plugin states.
+func (r *Runtime) setupBundleMetrics(m *runtimeMetrics) []prometheus.Collector {
+	m.bundleActivation = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "bundle_activation_duration_seconds",
+		Help:      "Duration of bundle loading and activation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	}, []string{"bundle"})
+	m.bundleActivations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bundle_activations_total",
+		Help:      "Number of bundle activations, including local bundle loads.",
+	}, []string{"bundle"})
+	m.bundleRevision = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "bundle_revision",
+		Help:      "Active revision of each bundle, set to 1.",
+	}, []string{"bundle", "revision"})
+	m.pluginState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "plugin_state",
+		Help:      "State of each plugin, set to 1 for the current state and 0 for the others.",
+	}, []string{"plugin", "state"})
+
+	return []prometheus.Collector{m.bundleActivation, m.bundleActivations, m.bundleRevision, m.pluginState}
+}
+
+// setupWatcherMetrics creates the instruments of local bundle reloads.
+func (r *Runtime) setupWatcherMetrics(m *runtimeMetrics) []prometheus.Collector {
+	m.watcherReload = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "watcher_reload_duration_seconds",
+		Help:      "Duration of local bundle reloads triggered by file changes.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+	m.watcherReloadError = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "watcher_reload_errors_total",
+		Help:      "Number of failed local bundle reloads.",
+	})
+
+	return []prometheus.Collector{m.watcherReload, m.watcherReloadError}
+}
+
+// setupCacheMetrics creates the instruments of the inter-query, prepared query and decision caches.
+func (r *Runtime) setupCacheMetrics(m *runtimeMetrics) []prometheus.Collector {
+	m.interQueryCache = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "inter_query_cache_total",
+		Help:      "Inter-query builtin cache lookups and evictions, by result (hit, miss or evicted).",
+	}, []string{"result"})
+
+	collectors := []prometheus.Collector{
+		m.interQueryCache,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "prepared_query_cache_size",
+			Help:      "Number of prepared queries in the cache.",
+		}, func() float64 {
+			return float64(r.preparedQueries.len())
+		}),
+	}
+
+	if r.decisionCache != nil {
+		collectors = append(collectors, newDecisionCacheCollector(r))
+	}
+
+	return collectors
+}
+
+// setupAdmissionMetrics creates the instruments of admission control, which are only registered if it is enabled.
+func (r *Runtime) setupAdmissionMetrics(m *runtimeMetrics) []prometheus.Collector {
+	m.admissionWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_wait_duration_seconds",
+		Help:      "Time evaluations waited for a slot before being admitted.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+	m.admissionRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "admission_rejected_total",
+		Help:      "Number of evaluations rejected by admission control, by reason (queue_full, max_wait or cancelled).",
+	}, []string{"reason"})
+
+	if r.admission == nil {
+		return nil
+	}
+
+	return []prometheus.Collector{
+		m.admissionWait,
+		m.admissionRejected,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "admission_in_flight",
+			Help:      "Number of evaluations holding a slot.",
+		}, func() float64 {
+			inFlight, _ := r.admission.stats()
+			return float64(inFlight)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "admission_queue_depth",
+			Help:      "Number of evaluations waiting for a slot.",
+		}, func() float64 {
+			_, queued := r.admission.stats()
+			return float64(queued)
+		}),
+	}
+}
+
+func (m *runtimeMetrics) observeQuery(op string, query ast.Body, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	entrypoint := ""
+	if ref, ok := queryEntrypoint(query); ok {
+		entrypoint = ref.String()
+	}
+
+	m.queryDuration.WithLabelValues(op, entrypoint).Observe(d.Seconds())
+
+	if err != nil {
+		m.queryErrors.WithLabelValues(op, entrypoint, errorReason(err)).Inc()
+	}
+}
+
+// errorReason classifies an evaluation error for the query errors counter.
+func errorReason(err error) string {
+	switch {
+	case errors.Is(err, ErrParse):
+		return "parse"
+	case errors.Is(err, ErrCompile):
+		return "compile"
+	case errors.Is(err, ErrInvalidInput):
+		return "invalid_input"
+	case errors.Is(err, ErrEvalBudgetExceeded):
+		return "budget_exceeded"
+	case errors.Is(err, ErrCancelled):
+		return "cancelled"
+	default:
+		return "eval"
+	}
+}
+
+func (m *runtimeMetrics) observeBundleActivation(name, previousRevision, revision string) {
+	if m == nil {
+		return
+	}
+
+	m.bundleActivations.WithLabelValues(name).Inc()
+
+	if previousRevision != revision {
+		m.bundleRevision.DeleteLabelValues(name, previousRevision)
+	}
+
+	m.bundleRevision.WithLabelValues(name, revision).Set(1)
+}
+
+func (m *runtimeMetrics) observeBundleActivationDuration(name string, d time.Duration) {
+	if m == nil {
+		return
+	}
+
+	m.bundleActivation.WithLabelValues(name).Observe(d.Seconds())
+}
+
+func (m *runtimeMetrics) setPluginState(name string, state plugins.State) {
+	if m == nil {
+		return
+	}
+
+	for _, s := range pluginStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+
+		m.pluginState.WithLabelValues(name, string(s)).Set(value)
+	}
+}
+
+func (m *runtimeMetrics) observeReload(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.watcherReload.Observe(d.Seconds())
+
+	if err != nil {
+		m.watcherReloadError.Inc()
+	}
+}
+
+func (m *runtimeMetrics) observeAdmission(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	var overloadErr *OverloadError
+
+	switch {
+	case err == nil:
+		m.admissionWait.Observe(d.Seconds())
+	case errors.As(err, &overloadErr):
+		m.admissionRejected.WithLabelValues(string(overloadErr.Reason)).Inc()
+	default:
+		m.admissionRejected.WithLabelValues("cancelled").Inc()
+	}
+}
+
+// instrumentCache wraps the inter-query builtin cache to count its hits, misses and evictions.
+func (m *runtimeMetrics) instrumentCache(c cache.InterQueryCache) cache.InterQueryCache {
+	if m == nil {
+		return c
+	}
+
+	return &instrumentedInterQueryCache{InterQueryCache: c, counter: m.interQueryCache}
+}
+
+type instrumentedInterQueryCache struct {
+	cache.InterQueryCache
+
+	counter *prometheus.CounterVec
+}
+
+func (c *instrumentedInterQueryCache) Get(key ast.Value) (cache.InterQueryCacheValue, bool) {
+	value, found := c.InterQueryCache.Get(key)
+	if found {
+		c.counter.WithLabelValues("hit").Inc()
+	} else {
+		c.counter.WithLabelValues("miss").Inc()
+	}
+
+	return value, found
+}
+
+func (c *instrumentedInterQueryCache) Insert(key ast.Value, value cache.InterQueryCacheValue) int {
+	dropped := c.InterQueryCache.Insert(key, value)
+	c.counter.WithLabelValues("evicted").Add(float64(dropped))
+
+	return dropped
+}
+
+func (c *instrumentedInterQueryCache) InsertWithExpiry(key ast.Value, value cache.InterQueryCacheValue, expiresAt time.Time) int {
+	dropped := c.InterQueryCache.InsertWithExpiry(key, value, expiresAt)
+	c.counter.WithLabelValues("evicted").Add(float64(dropped))
+
+	return dropped
+}
+
+// decisionCacheCollector exports DecisionCacheStats.
+type decisionCacheCollector struct {
+	r         *Runtime
+	hits      *prometheus.Desc
+	misses    *prometheus.Desc
+	evictions *prometheus.Desc
+	flushes   *prometheus.Desc
+	size      *prometheus.Desc
+}
+
+func newDecisionCacheCollector(r *Runtime) *decisionCacheCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "decision_cache", name), help, nil, nil)
+	}
+
+	return &decisionCacheCollector{
+		r:         r,
+		hits:      desc("hits_total", "Number of queries served from the decision cache."),
+		misses:    desc("misses_total", "Number of cacheable queries not found in the decision cache."),
+		evictions: desc("evictions_total", "Number of decisions evicted from the cache to stay within its size."),
+		flushes:   desc("flushes_total", "Number of times the decision cache was flushed."),
+		size:      desc("size", "Number of decisions in the cache."),
+	}
+}
+
+func (c *decisionCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.evictions
+	ch <- c.flushes
+	ch <- c.size
+}
+
+func (c *decisionCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	stats, err := c.r.DecisionCacheStats()
+	if err != nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+	ch <- prometheus.MustNewConstMetric(c.flushes, prometheus.CounterValue, float64(stats.Flushes))
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(stats.Size))
+}
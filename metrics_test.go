@@ -0,0 +1,89 @@
+package runtime_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrometheus(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+	reg := prometheus.NewRegistry()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSchemaBundle()},
+		},
+	}, runtime.WithPrometheus(reg), runtime.WithDecisionCache(10, time.Minute), runtime.WithAnnotatedInputSchemas())
+	assert.NoError(err)
+
+	// Act
+	_, err = r.Query(ctx, "data.schema.allowed", map[string]any{"user": "alice"}, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	_, err = r.Query(ctx, "data.schema.allowed", map[string]any{"user": "alice"}, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	_, err = r.Query(ctx, "data.schema.allowed", map[string]any{}, false, false, false, types.ExplainOffV1)
+	assert.Error(err)
+
+	_, err = r.Compile(ctx, "x = undefined_function(1)", nil, nil, nil, false, false, false, types.ExplainOffV1)
+	assert.Error(err)
+
+	// Assert
+	assert.NoError(promtestutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP runtime_query_errors_total Number of failed Query and Compile calls, by reason.
+# TYPE runtime_query_errors_total counter
+runtime_query_errors_total{entrypoint="",op="compile",reason="compile"} 1
+runtime_query_errors_total{entrypoint="data.schema.allowed",op="query",reason="invalid_input"} 1
+# HELP runtime_decision_cache_hits_total Number of queries served from the decision cache.
+# TYPE runtime_decision_cache_hits_total counter
+runtime_decision_cache_hits_total 1
+`), "runtime_query_errors_total", "runtime_decision_cache_hits_total"))
+
+	count, err := promtestutil.GatherAndCount(reg, "runtime_query_duration_seconds")
+	assert.NoError(err)
+	assert.Equal(2, count)
+
+	// registering the collectors twice fails.
+	_, err = runtime.New(ctx, &runtime.Config{}, runtime.WithPrometheus(reg))
+	assert.Error(err)
+}
+
+func TestPrometheusLocalBundle(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	reg := prometheus.NewRegistry()
+
+	// Act
+	_, err := runtime.New(t.Context(), &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	}, runtime.WithPrometheus(reg))
+	assert.NoError(err)
+
+	// Assert
+	// local bundles have no activation metrics, so only their activation and revision are recorded.
+	assert.NoError(promtestutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP runtime_bundle_activations_total Number of bundle activations, including local bundle loads.
+# TYPE runtime_bundle_activations_total counter
+runtime_bundle_activations_total{bundle="`+testutil.AssetSimpleBundle()+`"} 1
+# HELP runtime_bundle_revision Active revision of each bundle, set to 1.
+# TYPE runtime_bundle_revision gauge
+runtime_bundle_revision{bundle="`+testutil.AssetSimpleBundle()+`",revision=""} 1
+`), "runtime_bundle_activations_total", "runtime_bundle_revision"))
+
+	count, err := promtestutil.GatherAndCount(reg, "runtime_bundle_activation_duration_seconds")
+	assert.NoError(err)
+	assert.Zero(count)
+}
@@ -1,10 +1,14 @@
 package runtime
 
 import (
+	"time"
+
 	"github.com/open-policy-agent/opa/v1/ast"
 	"github.com/open-policy-agent/opa/v1/plugins"
 	"github.com/open-policy-agent/opa/v1/rego"
 	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Option func(*Runtime)
@@ -18,7 +22,6 @@ func WithPlugin(name string, factory plugins.Factory) Option {
 func WithBuiltin1(decl *rego.Function, impl rego.Builtin1) Option {
 	return func(r *Runtime) {
 		r.builtins1[decl] = impl
-		r.builtins = append(r.builtins, rego.Function1(decl, impl))
 		r.compilerBuiltins[decl.Name] = &ast.Builtin{
 			Name: decl.Name,
 			Decl: decl.Decl,
@@ -29,7 +32,6 @@ func WithBuiltin1(decl *rego.Function, impl rego.Builtin1) Option {
 func WithBuiltin2(decl *rego.Function, impl rego.Builtin2) Option {
 	return func(r *Runtime) {
 		r.builtins2[decl] = impl
-		r.builtins = append(r.builtins, rego.Function2(decl, impl))
 		r.compilerBuiltins[decl.Name] = &ast.Builtin{
 			Name: decl.Name,
 			Decl: decl.Decl,
@@ -40,7 +42,6 @@ func WithBuiltin2(decl *rego.Function, impl rego.Builtin2) Option {
 func WithBuiltin3(decl *rego.Function, impl rego.Builtin3) Option {
 	return func(r *Runtime) {
 		r.builtins3[decl] = impl
-		r.builtins = append(r.builtins, rego.Function3(decl, impl))
 		r.compilerBuiltins[decl.Name] = &ast.Builtin{
 			Name: decl.Name,
 			Decl: decl.Decl,
@@ -51,7 +52,6 @@ func WithBuiltin3(decl *rego.Function, impl rego.Builtin3) Option {
 func WithBuiltin4(decl *rego.Function, impl rego.Builtin4) Option {
 	return func(r *Runtime) {
 		r.builtins4[decl] = impl
-		r.builtins = append(r.builtins, rego.Function4(decl, impl))
 		r.compilerBuiltins[decl.Name] = &ast.Builtin{
 			Name: decl.Name,
 			Decl: decl.Decl,
@@ -62,7 +62,6 @@ func WithBuiltin4(decl *rego.Function, impl rego.Builtin4) Option {
 func WithBuiltinDyn(decl *rego.Function, impl rego.BuiltinDyn) Option {
 	return func(r *Runtime) {
 		r.builtinsDyn[decl] = impl
-		r.builtins = append(r.builtins, rego.FunctionDyn(decl, impl))
 		r.compilerBuiltins[decl.Name] = &ast.Builtin{
 			Name: decl.Name,
 			Decl: decl.Decl,
@@ -70,6 +69,28 @@ func WithBuiltinDyn(decl *rego.Function, impl rego.BuiltinDyn) Option {
 	}
 }
 
+// PartialEval is how partial evaluation treats the calls to a custom builtin.
+type PartialEval int
+
+const (
+	// PartialEvalDefault follows the builtin's declaration: calls to nondeterministic builtins are kept
+	// in the result, and the others are evaluated if their arguments are known.
+	PartialEvalDefault PartialEval = iota
+	// PartialEvalSafe evaluates the calls whose arguments are known.
+	PartialEvalSafe
+	// PartialEvalUnknown keeps every call in the result, as if its value was unknown.
+	PartialEvalUnknown
+)
+
+// WithBuiltinPartialEval sets how Compile treats the calls to the custom builtin with the given name.
+// It only applies to partial evaluation: the results of nondeterministic builtins are still recorded,
+// and the decisions depending on them are still not cached.
+func WithBuiltinPartialEval(name string, mode PartialEval) Option {
+	return func(r *Runtime) {
+		r.builtinsPartialEval[name] = mode
+	}
+}
+
 func WithStorage(storageInterface storage.Store) Option {
 	return func(r *Runtime) {
 		r.storage = storageInterface
@@ -93,3 +114,257 @@ func WithRegoVersion(v ast.RegoVersion) Option {
 		r.regoVersion = v
 	}
 }
+
+// WithBatchWorkers sets the maximum number of queries QueryBatch evaluates concurrently.
+// It defaults to GOMAXPROCS.
+func WithBatchWorkers(n int) Option {
+	return func(r *Runtime) {
+		if n > 0 {
+			r.batchWorkers = n
+		}
+	}
+}
+
+// WithUnsafeBuiltins sets the builtins that policies and queries are not allowed to call,
+// overriding Config.Builtins.Unsafe. Bundles calling them are rejected at activation.
+func WithUnsafeBuiltins(names ...string) Option {
+	return func(r *Runtime) {
+		r.unsafeBuiltins = make(map[string]struct{}, len(names))
+		for _, name := range names {
+			r.unsafeBuiltins[name] = struct{}{}
+		}
+	}
+}
+
+// WithHTTPSendAllowedHosts restricts the hosts http.send can reach, overriding Config.Builtins.HTTPSendAllowedHosts.
+func WithHTTPSendAllowedHosts(hosts ...string) Option {
+	return func(r *Runtime) {
+		r.httpSendAllowedHosts = make(map[string]struct{}, len(hosts))
+		for _, host := range hosts {
+			r.httpSendAllowedHosts[host] = struct{}{}
+		}
+	}
+}
+
+// WithEvalLimits sets the default evaluation limits applied to every Query and Compile call.
+func WithEvalLimits(limits EvalLimits) Option {
+	return func(r *Runtime) {
+		r.evalLimits = limits
+	}
+}
+
+// WithDecisionLogger sends a decision event for every Query and Compile call to logger,
+// instead of the OPA decision_logs plugin.
+func WithDecisionLogger(logger DecisionLogger) Option {
+	return func(r *Runtime) {
+		r.decisionLogger = logger
+	}
+}
+
+// WithInputSchema sets the JSON schema the input of queries for entrypoint (e.g. "data.example.allow") must match.
+// The schema can be a JSON document (string or []byte) or a value that can be converted to JSON.
+// It takes precedence over schemas from the entrypoint's "# METADATA" annotations, see WithAnnotatedInputSchemas.
+func WithInputSchema(entrypoint string, schema any) Option {
+	return func(r *Runtime) {
+		r.inputSchemaDefs[entrypoint] = schema
+	}
+}
+
+// WithAnnotatedInputSchemas validates the input of queries against the schemas of "# METADATA" annotations.
+// Annotations are otherwise not parsed, as they slow down the activation of large bundles.
+func WithAnnotatedInputSchemas() Option {
+	return func(r *Runtime) {
+		r.annotatedSchemas = true
+	}
+}
+
+// WithCoverage collects policy coverage from a fraction of queries, between 0 and 1, see CoverageReport.
+// Sampled queries are evaluated with a coverage tracer, which adds some overhead.
+func WithCoverage(sampleRate float64) Option {
+	return func(r *Runtime) {
+		if sampleRate > 0 {
+			r.coverage = newCoverage(min(sampleRate, 1))
+		}
+	}
+}
+
+// WithProfiler accumulates the evaluation time and counts of every expression across a fraction of queries,
+// between 0 and 1, see Profile. Sampled queries are evaluated with a profiler, which adds some overhead.
+func WithProfiler(sampleRate float64) Option {
+	return func(r *Runtime) {
+		if sampleRate > 0 {
+			r.profile = newProfile(min(sampleRate, 1))
+		}
+	}
+}
+
+// WithDecisionCache caches the results of up to maxSize queries for ttl, keyed by query and input.
+// The cache is flushed whenever policies or data change, e.g. when a bundle is activated.
+// Queries with an explanation or instrumentation, and results depending on non-deterministic builtins or on custom
+// builtins, unless declared with WithCacheableBuiltins, are not cached.
+func WithDecisionCache(maxSize int, ttl time.Duration) Option {
+	return func(r *Runtime) {
+		if maxSize > 0 && ttl > 0 {
+			r.decisionCache = newDecisionCache(maxSize, ttl)
+		}
+	}
+}
+
+// WithCacheableBuiltins declares that the results of the custom builtins with the given names only depend
+// on their arguments. The decisions calling other custom builtins are not stored in the decision cache.
+func WithCacheableBuiltins(names ...string) Option {
+	return func(r *Runtime) {
+		for _, name := range names {
+			r.cacheableBuiltins[name] = struct{}{}
+		}
+	}
+}
+
+// WithPrintStatements enables print() calls in policies and queries, which are otherwise removed at compile time.
+// Their output is logged or returned in Result.Prints, depending on output. WithPrintOutput overrides it for a single call.
+func WithPrintStatements(output PrintOutput) Option {
+	return func(r *Runtime) {
+		r.printStatements = true
+		r.printOutput = output
+	}
+}
+
+// WithPrometheus registers collectors for query durations and errors, bundle activations, plugin states,
+// local bundle reloads and caches with reg.
+func WithPrometheus(reg prometheus.Registerer) Option {
+	return func(r *Runtime) {
+		r.metricsRegisterer = reg
+	}
+}
+
+// WithTracerProvider traces Query, Compile and Build calls, local bundle loads and reloads using a tracer from tp.
+// Spans carry the decision ID, query, bundle revisions, result size and error, if any.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(r *Runtime) {
+		r.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithRecorder passes recordings of the queries selected by selector, and of calls with WithRecording, to recorder.
+// A recording holds everything needed to evaluate a decision again with Replay. Recorded queries bypass the decision cache.
+func WithRecorder(recorder Recorder, selector RecordSelector) Option {
+	return func(r *Runtime) {
+		r.recorder = recorder
+		r.recordSelector = selector
+	}
+}
+
+// WithAdmissionControl limits the number of Query, QueryBatch, QueryIter, QueryWhatIf and Compile calls evaluated
+// concurrently. Calls over the limit wait in a queue, and fail with an *OverloadError when it is full or they
+// wait too long.
+func WithAdmissionControl(cfg AdmissionConfig) Option {
+	return func(r *Runtime) {
+		if cfg.MaxConcurrent > 0 {
+			r.admission = newAdmission(cfg)
+		}
+	}
+}
+
+// WithWasmEvaluation restricts queries to the entrypoints of wasm bundles (see Build with the Wasm target).
+// Queries are still planned by the topdown evaluator, which resolves each entrypoint by executing the
+// bundle's compiled wasm module with the wasm engine. Queries that are not a compiled entrypoint fail with
+// ErrNotWasmEntrypoint, and Compile fails with ErrWasmUnsupported. Builtins registered with WithBuiltin1..Dyn
+// are provided to the wasm modules that import them. The runtime's own queries, such as the one listing
+// bundles in GetBundles, are evaluated by topdown.
+// The wasm engine is only linked when building with the opa_wasm tag, which requires cgo.
+func WithWasmEvaluation() Option {
+	return func(r *Runtime) {
+		r.wasmEvaluation = true
+	}
+}
+
+// QueryOption customizes a single Query or Compile call.
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	limits EvalLimits
+	// modules overlay the stored policies, see QueryWhatIf.
+	modules map[string]string
+	// explanationText renders the trace as text in Result.ExplanationText.
+	explanationText bool
+	// decisionID and metadata are set by WithDecisionID and WithDecisionMetadata.
+	decisionID string
+	metadata   map[string]any
+	// noDecisionCache bypasses the decision cache, see WithoutDecisionCache.
+	noDecisionCache bool
+	// printOutput overrides the runtime's print output, see WithPrintOutput.
+	printOutput *PrintOutput
+	// record records a single call, see WithRecording.
+	record bool
+	// internal marks the runtime's own queries, see internalQuery.
+	internal bool
+	// cacheGeneration is the decision cache generation when the query's transaction was opened.
+	cacheGeneration uint64
+}
+
+// WithQueryLimits overrides the runtime's evaluation limits for a single call.
+// Only non-zero limits are overridden.
+func WithQueryLimits(limits EvalLimits) QueryOption {
+	return func(o *queryOptions) {
+		o.limits = o.limits.override(limits)
+	}
+}
+
+// WithExplanationText returns the query trace rendered as indented text, with the location of every step,
+// in Result.ExplanationText. The trace is filtered according to the explain mode, and is complete if explain is off.
+func WithExplanationText() QueryOption {
+	return func(o *queryOptions) {
+		o.explanationText = true
+	}
+}
+
+// WithDecisionID sets the decision ID of a single call, instead of generating one.
+// It takes precedence over the decision ID of the context, see ContextWithDecisionID.
+func WithDecisionID(id string) QueryOption {
+	return func(o *queryOptions) {
+		o.decisionID = id
+	}
+}
+
+// WithDecisionMetadata attaches metadata (e.g. tenant, caller or request path) to the decision of a single call.
+// It is merged with the metadata of the context, see ContextWithDecisionMetadata, added to the logger fields
+// and decision logs, and returned in Result.Metadata.
+func WithDecisionMetadata(metadata map[string]any) QueryOption {
+	return func(o *queryOptions) {
+		o.metadata = metadata
+	}
+}
+
+// WithoutDecisionCache evaluates a single call without reading from or adding to the decision cache.
+func WithoutDecisionCache() QueryOption {
+	return func(o *queryOptions) {
+		o.noDecisionCache = true
+	}
+}
+
+// WithPrintOutput sets where the output of print() calls goes for a single call, if print statements are enabled.
+// Compile always logs it, and QueryIter returns it with the solution it precedes.
+func WithPrintOutput(output PrintOutput) QueryOption {
+	return func(o *queryOptions) {
+		o.printOutput = &output
+	}
+}
+
+// WithRecording records a single Query call, if a recorder is set with WithRecorder.
+func WithRecording() QueryOption {
+	return func(o *queryOptions) {
+		o.record = true
+	}
+}
+
+func (r *Runtime) queryOptions(opts []QueryOption) *queryOptions {
+	o := &queryOptions{
+		limits: r.evalLimits,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
@@ -0,0 +1,46 @@
+package runtime
+
+import (
+	"slices"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+)
+
+// setupPartialEval lists the builtins whose calls Compile keeps in its result, see WithBuiltinPartialEval.
+// Partial evaluation keeps the calls to the builtins passed as unknowns. If some nondeterministic builtins are
+// evaluated, every nondeterministic builtin is, except the ones passed as unknowns. Must be called with
+// builtinsLock held, as ast.Builtins also lists the builtins registered by other runtimes.
+func (r *Runtime) setupPartialEval() {
+	for name, mode := range r.builtinsPartialEval {
+		switch mode {
+		case PartialEvalUnknown:
+			r.partialEvalUnknowns = append(r.partialEvalUnknowns, name)
+		case PartialEvalSafe:
+			r.partialEvalNondeterministic = true
+		}
+	}
+
+	if r.partialEvalNondeterministic {
+		for _, bi := range ast.Builtins {
+			if bi.Nondeterministic && r.builtinsPartialEval[bi.Name] == PartialEvalDefault {
+				r.partialEvalUnknowns = append(r.partialEvalUnknowns, bi.Name)
+			}
+		}
+	}
+
+	slices.Sort(r.partialEvalUnknowns)
+}
+
+// compileUnknowns returns the unknowns of a Compile call, along with the builtins whose calls are kept.
+func (r *Runtime) compileUnknowns(unknowns []string) []string {
+	if len(r.partialEvalUnknowns) == 0 {
+		return unknowns
+	}
+
+	if unknowns == nil {
+		// the default unknowns of rego.
+		unknowns = []string{ast.InputRootDocument.String()}
+	}
+
+	return slices.Concat(unknowns, r.partialEvalUnknowns)
+}
@@ -2,6 +2,7 @@ package runtime
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
@@ -45,11 +46,11 @@ func (r *Runtime) WaitForPlugins(timeoutCtx context.Context, maxWaitTime time.Du
 		}
 
 		if len(errs) > 0 {
-			return errors.Wrap(multierror.Append(nil, errs...), "error loading plugins")
+			return fmt.Errorf("%w: error loading plugins: %w", ErrRuntimeNotReady, multierror.Append(nil, errs...))
 		}
 
 		if timeoutCtx.Err() != nil {
-			return errors.Wrap(timeoutCtx.Err(), "timeout while waiting for runtime to load")
+			return fmt.Errorf("%w: timeout while waiting for runtime to load: %w", ErrRuntimeNotReady, timeoutCtx.Err())
 		}
 
 		time.Sleep(pluginWait)
@@ -104,6 +105,8 @@ func (r *Runtime) bundlesStatusCallback(status bundle.Status) {
 		errs = append(errs, errors.Errorf("bundle error: %s", status.Message))
 	}
 
+	r.observeBundleActivation(status)
+
 	r.bundleStates.Store(status.Name, &bundleState{
 		revision:       status.ActiveRevision,
 		errors:         errs,
@@ -115,6 +118,31 @@ func (r *Runtime) bundlesStatusCallback(status bundle.Status) {
 	r.setLatestStatus(r.status())
 }
 
+// observeBundleActivation records the revision of a bundle if status reports a new activation, and its duration
+// if status has metrics, which local bundles don't.
+func (r *Runtime) observeBundleActivation(status bundle.Status) {
+	if r.metrics == nil || status.LastSuccessfulActivation.IsZero() {
+		return
+	}
+
+	previousRevision := ""
+
+	if v, ok := r.bundleStates.Load(status.Name); ok {
+		previous := v.(*bundleState)
+		if !status.LastSuccessfulActivation.After(previous.lastActivation) {
+			return
+		}
+
+		previousRevision = previous.revision
+	}
+
+	r.metrics.observeBundleActivation(status.Name, previousRevision, status.ActiveRevision)
+
+	if status.Metrics != nil {
+		r.metrics.observeBundleActivationDuration(status.Name, time.Duration(status.Metrics.Timer(metrics.RegoLoadBundles).Int64()))
+	}
+}
+
 func (r *Runtime) pluginStatusCallback(statusDetails map[string]*plugins.Status) {
 	for n, s := range statusDetails {
 		if n == bundlePluginName && !r.bundlesCallbackRegistered.Load() {
@@ -129,6 +157,8 @@ func (r *Runtime) pluginStatusCallback(statusDetails map[string]*plugins.Status)
 			continue
 		}
 
+		r.metrics.setPluginState(n, s.State)
+
 		switch s.State {
 		case plugins.StateErr:
 			r.Logger.Trace().Str("runtime-id", r.pluginsManager.ID).Str("plugin", n).Msg("plugin in error state")
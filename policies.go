@@ -51,5 +51,5 @@ func (r *Runtime) GetPolicy(ctx context.Context, id string) (*types.PolicyV1, er
 		}
 	}
 
-	return nil, err
+	return nil, &NotFoundError{Kind: "policy", ID: id}
 }
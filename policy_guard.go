@@ -0,0 +1,153 @@
+package runtime
+
+import (
+	"context"
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/plugins"
+	"github.com/open-policy-agent/opa/v1/storage"
+)
+
+// policyGuard wraps the store of a runtime to reject the commit of write transactions installing policies that
+// call unsafe builtins, so that the activation fails and the active policies stay in place.
+// Bundle activations set the compiler of the new policies on the transaction context, and its modules are checked.
+// Policies written without a compiler, e.g. by local bundle reloads, are parsed from the store.
+type policyGuard struct {
+	storage.Store
+
+	parserOptions ast.ParserOptions
+	check         func(modules map[string]*ast.Module) error
+
+	mu   sync.Mutex
+	txns map[storage.Transaction]*guardedTxn
+}
+
+// guardedTxn tracks the policies written by a write transaction.
+type guardedTxn struct {
+	context  *storage.Context
+	policies map[string]struct{}
+}
+
+var _ storage.Store = (*policyGuard)(nil)
+
+func newPolicyGuard(store storage.Store, parserOptions ast.ParserOptions, check func(map[string]*ast.Module) error) *policyGuard {
+	return &policyGuard{
+		Store:         store,
+		parserOptions: parserOptions,
+		check:         check,
+		txns:          map[storage.Transaction]*guardedTxn{},
+	}
+}
+
+// NewTransaction is called to create a new transaction in the store.
+func (g *policyGuard) NewTransaction(ctx context.Context, params ...storage.TransactionParams) (storage.Transaction, error) {
+	txn, err := g.Store.NewTransaction(ctx, params...)
+	if err != nil || len(params) == 0 || !params[0].Write {
+		return txn, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.txns[txn] = &guardedTxn{context: params[0].Context, policies: map[string]struct{}{}}
+
+	return txn, nil
+}
+
+// UpsertPolicy creates a policy, or updates it if it already exists.
+func (g *policyGuard) UpsertPolicy(ctx context.Context, txn storage.Transaction, id string, bs []byte) error {
+	if err := g.Store.UpsertPolicy(ctx, txn, id, bs); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if t, ok := g.txns[txn]; ok {
+		t.policies[id] = struct{}{}
+	}
+
+	return nil
+}
+
+// DeletePolicy deletes a policy.
+func (g *policyGuard) DeletePolicy(ctx context.Context, txn storage.Transaction, id string) error {
+	if err := g.Store.DeletePolicy(ctx, txn, id); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if t, ok := g.txns[txn]; ok {
+		delete(t.policies, id)
+	}
+
+	return nil
+}
+
+// Commit is called to finish the transaction. The transaction is aborted if it installs policies calling
+// unsafe builtins.
+func (g *policyGuard) Commit(ctx context.Context, txn storage.Transaction) error {
+	if err := g.verify(ctx, txn, g.release(txn)); err != nil {
+		g.Store.Abort(ctx, txn)
+		return err
+	}
+
+	return g.Store.Commit(ctx, txn)
+}
+
+// Abort is called to cancel the transaction.
+func (g *policyGuard) Abort(ctx context.Context, txn storage.Transaction) {
+	g.release(txn)
+	g.Store.Abort(ctx, txn)
+}
+
+// Close closes the wrapped store, if it can be closed.
+func (g *policyGuard) Close(ctx context.Context) error {
+	if closer, ok := g.Store.(interface{ Close(context.Context) error }); ok {
+		return closer.Close(ctx)
+	}
+
+	return nil
+}
+
+func (g *policyGuard) release(txn storage.Transaction) *guardedTxn {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t := g.txns[txn]
+	delete(g.txns, txn)
+
+	return t
+}
+
+func (g *policyGuard) verify(ctx context.Context, txn storage.Transaction, t *guardedTxn) error {
+	if t == nil {
+		return nil
+	}
+
+	if compiler := plugins.GetCompilerOnContext(t.context); compiler != nil {
+		return g.check(compiler.Modules)
+	}
+
+	modules := make(map[string]*ast.Module, len(t.policies))
+
+	for id := range t.policies {
+		bs, err := g.Store.GetPolicy(ctx, txn, id)
+		if err != nil {
+			return err
+		}
+
+		// policies that do not parse are reported by the plugins manager when it compiles them.
+		module, err := ast.ParseModuleWithOpts(id, string(bs), g.parserOptions)
+		if err != nil {
+			continue
+		}
+
+		modules[id] = module
+	}
+
+	return g.check(modules)
+}
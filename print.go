@@ -0,0 +1,98 @@
+package runtime
+
+import (
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/topdown/print"
+	"github.com/rs/zerolog"
+)
+
+// PrintOutput is where the output of print() calls goes, see WithPrintStatements.
+type PrintOutput int
+
+const (
+	// PrintToLogger logs print() output with the runtime's logger, tagged with the decision ID and the call's location.
+	PrintToLogger PrintOutput = iota
+	// PrintToResult returns print() output to the caller in Result.Prints.
+	PrintToResult
+)
+
+// Print is the output of a single print() call.
+type Print struct {
+	Location *ast.Location `json:"location,omitempty"`
+	Message  string        `json:"message"`
+}
+
+// printHook receives the print() output of a single evaluation.
+type printHook struct {
+	output PrintOutput
+
+	mu     sync.Mutex
+	prints []Print
+}
+
+// printHook returns the print hook of an evaluation, or nil if print statements are not enabled.
+func (r *Runtime) printHook(qo *queryOptions) *printHook {
+	if !r.printStatements {
+		return nil
+	}
+
+	output := r.printOutput
+	if qo.printOutput != nil {
+		output = *qo.printOutput
+	}
+
+	return &printHook{output: output}
+}
+
+func (h *printHook) Print(pctx print.Context, msg string) error {
+	if h.output == PrintToLogger {
+		event := zerolog.Ctx(pctx.Context).Info()
+		if pctx.Location != nil {
+			event = event.Str("location", pctx.Location.String())
+		}
+
+		event.Msg(msg)
+
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.prints = append(h.prints, Print{Location: pctx.Location, Message: msg})
+
+	return nil
+}
+
+// capturing returns true if the output of print() calls is returned to the caller.
+func (h *printHook) capturing() bool {
+	return h != nil && h.output == PrintToResult
+}
+
+func (h *printHook) result() []Print {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.prints
+}
+
+// drain returns the output captured since the previous call.
+func (h *printHook) drain() []Print {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	prints := h.prints
+	h.prints = nil
+
+	return prints
+}
@@ -0,0 +1,70 @@
+package runtime_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+const printPolicy = `package printing
+
+import rego.v1
+
+allowed if {
+	print("user:", input.user)
+	input.user == "alice"
+}
+`
+
+func TestPrintStatements(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+
+	var buf bytes.Buffer
+
+	logger := zerolog.New(&buf)
+	ctx := logger.WithContext(t.Context())
+
+	r, err := runtime.New(ctx, &runtime.Config{}, runtime.WithPrintStatements(runtime.PrintToResult))
+	assert.NoError(err)
+
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "print.rego", []byte(printPolicy))
+	})
+	assert.NoError(err)
+
+	input := map[string]any{"user": "alice"}
+
+	// Act
+	captured, err := r.Query(ctx, "data.printing.allowed", input, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	logged, err := r.Query(ctx, "data.printing.allowed", input, false, false, false, types.ExplainOffV1,
+		runtime.WithPrintOutput(runtime.PrintToLogger))
+	assert.NoError(err)
+
+	// Assert
+	assert.Len(captured.Prints, 1)
+	assert.Equal("user: alice", captured.Prints[0].Message)
+	assert.Equal("print.rego", captured.Prints[0].Location.File)
+	assert.Equal(6, captured.Prints[0].Location.Row)
+
+	assert.Empty(logged.Prints)
+
+	var entry map[string]any
+
+	for line := range bytes.SplitSeq(buf.Bytes(), []byte("\n")) {
+		if bytes.Contains(line, []byte("user: alice")) {
+			assert.NoError(json.Unmarshal(line, &entry))
+		}
+	}
+
+	assert.Equal(logged.DecisionID, entry["decisionID"])
+	assert.Equal("print.rego:6", entry["location"])
+}
@@ -0,0 +1,225 @@
+package runtime
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/profiler"
+	"github.com/pkg/errors"
+)
+
+// RuleProfile holds the evaluation time and counts accumulated by the expressions of a rule.
+type RuleProfile struct {
+	// Policy is the ID of the policy the rule is defined in, as returned by ListPolicies.
+	Policy string `json:"policy"`
+	// Rule is the rule's reference, e.g. "data.example.allowed".
+	Rule    string `json:"rule"`
+	Row     int    `json:"row"`
+	TimeNs  int64  `json:"total_time_ns"`
+	NumEval int    `json:"num_eval"`
+	NumRedo int    `json:"num_redo"`
+}
+
+// ProfileReport lists the rules and expressions that took the most time to evaluate, slowest first.
+// Expression locations refer to policy IDs.
+type ProfileReport struct {
+	Rules []RuleProfile        `json:"rules"`
+	Exprs []profiler.ExprStats `json:"exprs"`
+
+	// Queries is the number of queries the profile was collected from.
+	Queries uint64 `json:"queries"`
+}
+
+type exprKey struct {
+	file string
+	row  int
+}
+
+// profile accumulates expression statistics from a sampled fraction of queries.
+type profile struct {
+	sampleRate float64
+	queries    atomic.Uint64
+
+	mu    sync.Mutex
+	exprs map[exprKey]profiler.ExprStats
+}
+
+func newProfile(sampleRate float64) *profile {
+	return &profile{
+		sampleRate: sampleRate,
+		exprs:      map[exprKey]profiler.ExprStats{},
+	}
+}
+
+// tracer returns a profiler for a single query if it is sampled, nil otherwise.
+// Profilers keep per-query state, so their results are merged into the profile by record.
+func (p *profile) tracer() *profiler.Profiler {
+	if p == nil || rand.Float64() >= p.sampleRate { //nolint:gosec
+		return nil
+	}
+
+	return profiler.New()
+}
+
+func (p *profile) record(prof *profiler.Profiler) {
+	if p == nil || prof == nil {
+		return
+	}
+
+	report := prof.ReportByFile()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queries.Add(1)
+
+	for file, fr := range report.Files {
+		for _, stat := range fr.Result {
+			key := exprKey{file: file, row: stat.Location.Row}
+
+			total, ok := p.exprs[key]
+			if !ok {
+				total.Location = stat.Location
+			}
+
+			total.ExprTimeNs += stat.ExprTimeNs
+			total.NumEval += stat.NumEval
+			total.NumRedo += stat.NumRedo
+			total.NumGenExpr = max(total.NumGenExpr, stat.NumGenExpr)
+
+			p.exprs[key] = total
+		}
+	}
+}
+
+func (p *profile) reset() {
+	if p == nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.exprs = map[exprKey]profiler.ExprStats{}
+	p.queries.Store(0)
+}
+
+func (p *profile) stats() []profiler.ExprStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := make([]profiler.ExprStats, 0, len(p.exprs))
+	for _, stat := range p.exprs {
+		stats = append(stats, stat)
+	}
+
+	return stats
+}
+
+// Profile returns the n rules and expressions that took the most time to evaluate since profiling was enabled,
+// reset, or the policies last changed. If n <= 0, all of them are returned.
+func (r *Runtime) Profile(n int) (*ProfileReport, error) {
+	if r.profile == nil {
+		return nil, errors.New("profiling is not enabled")
+	}
+
+	ids, modules := policyFiles(r.pluginsManager.GetCompiler())
+
+	rules := map[*ast.Rule]*RuleProfile{}
+	report := &ProfileReport{
+		Rules:   []RuleProfile{},
+		Exprs:   []profiler.ExprStats{},
+		Queries: r.profile.queries.Load(),
+	}
+
+	for _, stat := range r.profile.stats() {
+		id, ok := ids[stat.Location.File]
+		if !ok {
+			// expressions of the query itself.
+			continue
+		}
+
+		loc := *stat.Location
+		loc.File = id
+		stat.Location = &loc
+
+		report.Exprs = append(report.Exprs, stat)
+
+		rule := enclosingRule(modules[id], loc.Row)
+		if rule == nil {
+			continue
+		}
+
+		rp, ok := rules[rule]
+		if !ok {
+			rp = &RuleProfile{Policy: id, Rule: rule.Path().String(), Row: rule.Location.Row}
+			rules[rule] = rp
+		}
+
+		rp.TimeNs += stat.ExprTimeNs
+		rp.NumEval += stat.NumEval
+		rp.NumRedo += stat.NumRedo
+	}
+
+	for _, rp := range rules {
+		report.Rules = append(report.Rules, *rp)
+	}
+
+	slices.SortFunc(report.Rules, func(a, b RuleProfile) int {
+		return cmp.Or(cmp.Compare(b.TimeNs, a.TimeNs), cmp.Compare(a.Rule, b.Rule))
+	})
+	slices.SortFunc(report.Exprs, func(a, b profiler.ExprStats) int {
+		return cmp.Or(cmp.Compare(b.ExprTimeNs, a.ExprTimeNs), cmp.Compare(a.Location.File, b.Location.File), cmp.Compare(a.Location.Row, b.Location.Row))
+	})
+
+	if n > 0 {
+		report.Rules = report.Rules[:min(n, len(report.Rules))]
+		report.Exprs = report.Exprs[:min(n, len(report.Exprs))]
+	}
+
+	return report, nil
+}
+
+// ResetProfile discards the profile collected so far.
+func (r *Runtime) ResetProfile() {
+	r.profile.reset()
+}
+
+// enclosingRule returns the last rule of module starting at or before row.
+func enclosingRule(module *ast.Module, row int) *ast.Rule {
+	var found *ast.Rule
+
+	for _, rule := range module.Rules {
+		if rule.Location == nil || rule.Location.Row > row {
+			continue
+		}
+
+		if found == nil || rule.Location.Row > found.Location.Row {
+			found = rule
+		}
+	}
+
+	return found
+}
+
+// policyFiles maps the files of the compiler's modules, as found in expression locations, to policy IDs and modules.
+func policyFiles(compiler *ast.Compiler) (map[string]string, map[string]*ast.Module) {
+	ids := map[string]string{}
+	modules := map[string]*ast.Module{}
+
+	for id, module := range compiler.Modules {
+		file := id
+		if module.Package != nil && module.Package.Location != nil {
+			file = module.Package.Location.File
+		}
+
+		ids[file] = id
+		modules[id] = module
+	}
+
+	return ids, modules
+}
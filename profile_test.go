@@ -0,0 +1,71 @@
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfile(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSchemaBundle()},
+		},
+	}, runtime.WithProfiler(1))
+	assert.NoError(err)
+
+	policies, err := r.ListPolicies(ctx)
+	assert.NoError(err)
+	assert.Len(policies, 1)
+
+	// Act
+	for range 3 {
+		_, err = r.Query(ctx, "data.schema.allowed", map[string]any{"user": "alice"}, false, false, false, types.ExplainOffV1)
+		assert.NoError(err)
+	}
+
+	report, err := r.Profile(1)
+	assert.NoError(err)
+
+	r.ResetProfile()
+
+	reset, err := r.Profile(0)
+	assert.NoError(err)
+
+	// Assert
+	assert.Equal(uint64(3), report.Queries)
+	assert.Len(report.Rules, 1)
+	assert.Equal("data.schema.allowed", report.Rules[0].Rule)
+	assert.Equal(policies[0].ID, report.Rules[0].Policy)
+	assert.Equal(8, report.Rules[0].Row)
+	assert.Equal(3, report.Rules[0].NumEval)
+
+	assert.Len(report.Exprs, 1)
+	assert.Equal(policies[0].ID, report.Exprs[0].Location.File)
+	assert.Equal(8, report.Exprs[0].Location.Row)
+
+	assert.Equal(uint64(0), reset.Queries)
+	assert.Empty(reset.Rules)
+	assert.Empty(reset.Exprs)
+}
+
+func TestProfileDisabled(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+
+	r, err := runtime.New(t.Context(), &runtime.Config{})
+	assert.NoError(err)
+
+	// Act
+	_, err = r.Profile(10)
+
+	// Assert
+	assert.Error(err)
+}
@@ -3,27 +3,38 @@ package runtime
 import (
 	"context"
 	"fmt"
+	"slices"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/cover"
 	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/profiler"
 	"github.com/open-policy-agent/opa/v1/rego"
 	"github.com/open-policy-agent/opa/v1/server/types"
 	"github.com/open-policy-agent/opa/v1/storage"
 	"github.com/open-policy-agent/opa/v1/topdown"
-	"github.com/open-policy-agent/opa/v1/topdown/lineage"
 	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// map of unsafe builtins.
-var unsafeBuiltinsMap = map[string]struct{}{ast.HTTPSend.Name: {}}
-
 // Result contains the results of a Query execution.
 type Result struct {
 	Result      rego.ResultSet
 	Metrics     map[string]any
 	Explanation types.TraceV1
 	DecisionID  string
+	// Metadata is the decision metadata, see WithDecisionMetadata.
+	Metadata map[string]any
+	// ExplanationText is the trace rendered as text, see WithExplanationText.
+	ExplanationText string
+	// Prints holds the output of print() calls, see WithPrintStatements.
+	Prints []Print
+
+	// trace is the full trace of the evaluation, see RenderExplanation.
+	trace       []*topdown.Event
+	explainMode types.ExplainModeV1
 }
 
 // Query executes a REGO query against the Aserto OPA Runtime
@@ -34,28 +45,44 @@ func (r *Runtime) Query(
 	input map[string]any,
 	pretty, includeMetrics, includeInstrumentation bool,
 	explain types.ExplainModeV1,
+	opts ...QueryOption,
 ) (*Result, error) {
 	m := metrics.New()
 
-	decisionID := uuid.New().String()
+	qo := r.queryOptions(opts)
+	ctx, decisionID := r.decisionContext(ctx, qo)
+
+	ctx, span := r.startSpan(ctx, "runtime.Query", attribute.String(attrDecisionID, decisionID), attribute.String(attrQuery, qStr))
+	defer span.End()
 
 	parsedQuery, err := r.ValidateQuery(qStr)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to validate query")
+		return nil, spanError(span, errors.Wrap(err, "failed to validate query"))
+	}
+
+	release, err := r.admit(ctx)
+	if err != nil {
+		return nil, spanError(span, err)
 	}
 
-	txn, err := r.storage.NewTransaction(ctx)
+	defer release()
+
+	txn, err := r.newQueryTransaction(ctx, qo)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create new OPA store transaction")
+		return nil, spanError(span, errors.Wrap(err, "failed to create new OPA store transaction"))
 	}
 
 	defer r.storage.Abort(ctx, txn)
 
-	results, err := r.execQuery(ctx, txn, decisionID, parsedQuery, input, m, explain, includeMetrics, includeInstrumentation, pretty)
+	r.setSpanRevisions(ctx, span, txn)
+
+	results, err := r.execQuery(ctx, txn, decisionID, parsedQuery, input, m, explain, includeMetrics, includeInstrumentation, pretty, qo)
 	if err != nil {
-		return nil, errors.Wrapf(err, "query execution failed, decision-id: [%s], query: [%s]", decisionID, qStr)
+		return nil, spanError(span, evalError(decisionID, qStr, err))
 	}
 
+	span.SetAttributes(attribute.Int(attrResultSize, len(results.Result)))
+
 	return results, nil
 }
 
@@ -64,7 +91,7 @@ func (r *Runtime) ValidateQuery(query string) (ast.Body, error) {
 
 	body, err := ast.ParseBody(query)
 	if err != nil {
-		return nil, err
+		return nil, parseError(err)
 	}
 
 	return body, nil
@@ -91,106 +118,269 @@ func (r *Runtime) execQuery(
 	m metrics.Metrics,
 	explainMode types.ExplainModeV1,
 	includeMetrics, includeInstrumentation, pretty bool,
+	qo *queryOptions,
 ) (*Result, error) {
-	var buf *topdown.BufferTracer
-	if explainMode != types.ExplainOffV1 {
-		buf = topdown.NewBufferTracer()
+	timestamp := time.Now().UTC()
+
+	results, err := r.evalQuery(ctx, txn, decisionID, parsedQuery, input, m, explainMode, includeMetrics, includeInstrumentation, pretty, qo)
+	r.metrics.observeQuery("query", parsedQuery, time.Since(timestamp), err)
+
+	// the runtime's own queries are not decisions.
+	if qo.internal {
+		return results, err
 	}
 
-	opts := r.builtins
+	d := &decision{
+		txn:        txn,
+		decisionID: decisionID,
+		query:      parsedQuery.String(),
+		input:      input,
+		metrics:    m,
+		metadata:   DecisionMetadataFromContext(ctx),
+		err:        err,
+		timestamp:  timestamp,
+	}
 
-	compiler := r.pluginsManager.GetCompiler()
+	if results != nil {
+		d.results = results.Result
+	}
 
-	opts = append(opts,
-		rego.Store(r.storage),
-		rego.Transaction(txn),
-		rego.Compiler(compiler),
-		rego.ParsedQuery(parsedQuery),
-		rego.Metrics(m),
-		rego.Instrument(includeInstrumentation),
-		rego.QueryTracer(buf),
-		rego.Trace(true),
-		rego.Runtime(r.pluginsManager.Info),
-		rego.UnsafeBuiltins(unsafeBuiltinsMap),
-		rego.InterQueryBuiltinCache(r.InterQueryCache),
-		rego.Input(input),
-		rego.Imports(r.imports),
-	)
-
-	for _, r := range r.pluginsManager.GetWasmResolvers() {
-		for _, entrypoint := range r.Entrypoints() {
-			opts = append(opts, rego.Resolver(entrypoint, r))
-		}
+	r.logDecision(ctx, d)
+
+	return results, err
+}
+
+func (r *Runtime) evalQuery(
+	ctx context.Context,
+	txn storage.Transaction,
+	decisionID string,
+	parsedQuery ast.Body,
+	input map[string]any,
+	m metrics.Metrics,
+	explainMode types.ExplainModeV1,
+	includeMetrics, includeInstrumentation, pretty bool,
+	qo *queryOptions,
+) (*Result, error) {
+	if err := r.checkQuery(ctx, decisionID, parsedQuery, input, qo); err != nil {
+		return nil, err
 	}
 
-	regoQuery := rego.New(opts...)
+	hook := r.printHook(qo)
+	recording := r.recording(decisionID, parsedQuery, input, qo)
 
-	output, err := regoQuery.Eval(ctx)
+	cached, miss, hit := r.cachedDecision(parsedQuery, input, m, explainMode, includeInstrumentation,
+		hook.capturing() || recording != nil, qo)
+	if hit {
+		return r.queryResult(ctx, decisionID, cached, m, includeMetrics, false), nil
+	}
+
+	budget := newEvalBudget(ctx, qo.limits)
+	defer budget.release()
+
+	ctx = miss.context(budget.ctx)
+
+	pq, err := r.prepareQuery(ctx, txn, parsedQuery, m, qo)
 	if err != nil {
-		r.Logger.Warn().
-			Err(err).Str("decisionID", decisionID).
+		zerolog.Ctx(ctx).Warn().
+			Err(err).
 			Str("query", parsedQuery.String()).
-			Interface("input", input).
-			Msg("error evaluating query")
+			Msg("error preparing query")
 
-		return nil, errors.Wrap(err, "failed to evaluate rego query")
+		return nil, errors.Wrap(compileError(err), "failed to prepare rego query")
 	}
 
-	results := &Result{
-		Result:     output,
-		DecisionID: decisionID,
+	tracers := r.newQueryTracers(explainMode != types.ExplainOffV1 || qo.explanationText, budget)
+
+	evalOpts := r.evalOptions(txn, input, m, includeInstrumentation, hook, recording)
+	evalOpts = append(evalOpts, miss.evalOptions()...)
+
+	for _, tracer := range tracers.list() {
+		evalOpts = append(evalOpts, rego.EvalQueryTracer(tracer))
 	}
 
-	if includeMetrics || includeInstrumentation {
-		results.Metrics = m.All()
+	output, err := pq.Eval(ctx, evalOpts...)
+	r.recordTracers(tracers)
+
+	if budgetErr := budget.err(decisionID); budgetErr != nil {
+		err = budgetErr
 	}
 
-	if explainMode != types.ExplainOffV1 {
-		results.Explanation = r.getExplainResponse(explainMode, *buf, pretty)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().
+			Err(err).
+			Str("query", parsedQuery.String()).
+			Interface("input", input).
+			Msg("error evaluating query")
+
+		return nil, errors.Wrap(cancelError(err), "failed to evaluate rego query")
 	}
 
-	r.Logger.Debug().
-		Err(err).Str("decisionID", decisionID).
+	miss.put(output)
+
+	if recording != nil {
+		r.record(ctx, txn, recording, output)
+	}
+
+	results := r.queryResult(ctx, decisionID, output, m, includeMetrics, includeInstrumentation)
+	results.Prints = hook.result()
+	r.explain(results, explainMode, tracers.explain, pretty, qo)
+
+	zerolog.Ctx(ctx).Debug().
 		Str("query", parsedQuery.String()).
 		Interface("input", input).
 		Msg("query evaluated")
 
-	return results, err
+	return results, nil
 }
 
-func (r *Runtime) getExplainResponse(explainMode types.ExplainModeV1, trace []*topdown.Event, pretty bool) types.TraceV1 {
-	switch explainMode {
-	case types.ExplainNotesV1:
-		if explanation, err := types.NewTraceV1(lineage.Notes(trace), pretty); err == nil {
-			return explanation
-		}
+// checkQuery returns an error if a query cannot be evaluated in wasm mode, or its input is too large
+// or doesn't match the entrypoint's schema.
+func (r *Runtime) checkQuery(ctx context.Context, decisionID string, parsedQuery ast.Body, input map[string]any, qo *queryOptions) error {
+	if err := r.checkWasmQuery(parsedQuery, qo); err != nil {
+		return err
+	}
 
-		return nil
+	if err := qo.limits.checkInput(decisionID, input); err != nil {
+		return err
+	}
 
-	case types.ExplainFailsV1:
-		if explanation, err := types.NewTraceV1(lineage.Fails(trace), pretty); err == nil {
-			return explanation
-		}
+	return r.validateInput(ctx, decisionID, parsedQuery, input)
+}
 
-		return nil
+// evalOptions returns the options a prepared query is evaluated with, other than its tracers.
+func (r *Runtime) evalOptions(
+	txn storage.Transaction,
+	input map[string]any,
+	m metrics.Metrics,
+	includeInstrumentation bool,
+	hook *printHook,
+	recording *Recording,
+) []rego.EvalOption {
+	evalOpts := []rego.EvalOption{
+		rego.EvalTransaction(txn),
+		rego.EvalInput(input),
+		rego.EvalMetrics(m),
+		rego.EvalInstrument(includeInstrumentation),
+		rego.EvalInterQueryBuiltinCache(r.InterQueryCache),
+	}
 
-	case types.ExplainDebugV1:
-		if explanation, err := types.NewTraceV1(lineage.Debug(trace), pretty); err == nil {
-			return explanation
-		}
+	if recording != nil {
+		evalOpts = append(evalOpts, rego.EvalTime(recording.EvalTime), rego.EvalNDBuiltinCache(recording.NDBuiltinCache))
+	}
 
-		return nil
+	if hook != nil {
+		evalOpts = append(evalOpts, rego.EvalPrintHook(hook))
+	}
 
-	case types.ExplainFullV1:
-		if explanation, err := types.NewTraceV1(trace, pretty); err == nil {
-			return explanation
+	for _, resolver := range r.pluginsManager.GetWasmResolvers() {
+		for _, entrypoint := range resolver.Entrypoints() {
+			evalOpts = append(evalOpts, rego.EvalResolver(entrypoint, resolver))
 		}
+	}
 
-		return nil
+	return evalOpts
+}
+
+// queryTracers are the tracers of a single evaluation.
+type queryTracers struct {
+	// explain collects the trace returned as an explanation, if any.
+	explain  *topdown.BufferTracer
+	coverage *cover.Cover
+	profile  *profiler.Profiler
+	budget   []topdown.QueryTracer
+}
+
+func (r *Runtime) newQueryTracers(explain bool, budget *evalBudget) *queryTracers {
+	t := &queryTracers{
+		coverage: r.coverage.tracer(),
+		profile:  r.profile.tracer(),
+		budget:   budget.tracers(),
+	}
+
+	if explain {
+		t.explain = topdown.NewBufferTracer()
+	}
+
+	return t
+}
+
+// list returns the tracers that are set.
+func (t *queryTracers) list() []topdown.QueryTracer {
+	tracers := slices.Clone(t.budget)
+
+	if t.explain != nil {
+		tracers = append(tracers, t.explain)
+	}
+
+	if t.coverage != nil {
+		tracers = append(tracers, t.coverage)
+	}
+
+	if t.profile != nil {
+		tracers = append(tracers, t.profile)
+	}
+
+	return tracers
+}
+
+// recordTracers merges the coverage and profile of an evaluation into the runtime's.
+func (r *Runtime) recordTracers(t *queryTracers) {
+	r.coverage.record(t.coverage)
+	r.profile.record(t.profile)
+}
+
+func (r *Runtime) queryResult(
+	ctx context.Context,
+	decisionID string,
+	output rego.ResultSet,
+	m metrics.Metrics,
+	includeMetrics, includeInstrumentation bool,
+) *Result {
+	results := &Result{
+		Result:     output,
+		DecisionID: decisionID,
+		Metadata:   DecisionMetadataFromContext(ctx),
+	}
+
+	if includeMetrics || includeInstrumentation {
+		results.Metrics = m.All()
+	}
 
-	case types.ExplainOffV1:
+	return results
+}
+
+// explain sets the explanation of a result from its trace.
+func (r *Runtime) explain(
+	results *Result,
+	explainMode types.ExplainModeV1,
+	trace *topdown.BufferTracer,
+	pretty bool,
+	qo *queryOptions,
+) {
+	if trace == nil {
+		return
+	}
+
+	results.trace = *trace
+	results.explainMode = explainMode
+
+	if explainMode != types.ExplainOffV1 {
+		results.Explanation = r.getExplainResponse(explainMode, *trace, pretty)
+	}
+
+	if qo.explanationText {
+		results.ExplanationText = results.RenderExplanation()
+	}
+}
+
+func (r *Runtime) getExplainResponse(explainMode types.ExplainModeV1, trace []*topdown.Event, pretty bool) types.TraceV1 {
+	if explainMode == types.ExplainOffV1 {
 		return nil
 	}
 
+	if explanation, err := types.NewTraceV1(explainTrace(explainMode, trace), pretty); err == nil {
+		return explanation
+	}
+
 	return nil
 }
@@ -0,0 +1,164 @@
+package runtime
+
+import (
+	"container/list"
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage"
+)
+
+const (
+	// MetricQueryCacheHit counts queries served from the prepared query cache.
+	MetricQueryCacheHit = "runtime_query_cache_hit"
+	// MetricQueryCacheMiss counts queries that had to be prepared before evaluation.
+	MetricQueryCacheMiss = "runtime_query_cache_miss"
+
+	defaultQueryCacheSize = 1000
+)
+
+type preparedQueryEntry[T any] struct {
+	key      string
+	compiler *ast.Compiler
+	query    T
+}
+
+// preparedQueryCache is an LRU cache of prepared queries (or queries compiled for QueryIter), keyed by query,
+// along with the compiler they were prepared against. Entries prepared against a compiler other than the current
+// one are treated as misses.
+type preparedQueryCache[T any] struct {
+	mu      sync.Mutex
+	maxSize int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+func newPreparedQueryCache[T any](maxSize int) *preparedQueryCache[T] {
+	return &preparedQueryCache[T]{
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *preparedQueryCache[T]) get(key string, compiler *ast.Compiler) (T, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok || elem.Value.(*preparedQueryEntry[T]).compiler != compiler {
+		var zero T
+		return zero, false
+	}
+
+	c.lru.MoveToFront(elem)
+
+	return elem.Value.(*preparedQueryEntry[T]).query, true
+}
+
+func (c *preparedQueryCache[T]) put(key string, compiler *ast.Compiler, pq T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	for c.lru.Len() >= c.maxSize {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[key] = c.lru.PushFront(&preparedQueryEntry[T]{key: key, compiler: compiler, query: pq})
+}
+
+func (c *preparedQueryCache[T]) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*preparedQueryEntry[T]).key)
+}
+
+func (c *preparedQueryCache[T]) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *preparedQueryCache[T]) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Init()
+	clear(c.entries)
+}
+
+// onCompilerChange is registered as a compiler trigger with the plugins manager.
+// It applies the builtin restrictions to the new compiler, and drops all prepared queries, cached decisions,
+// entrypoint schemas, collected coverage and profiles whenever a bundle activation, discovery or a local bundle
+// reload installs a new compiler.
+func (r *Runtime) onCompilerChange(compiler *ast.Compiler) {
+	r.Logger.Trace().Msg("compiler changed, clearing prepared query cache")
+	r.restrictCompiler(compiler)
+	r.preparedQueries.clear()
+	r.compiledQueries.clear()
+	r.decisionCache.flush()
+	r.entrypointSchemas.Clear()
+	r.coverage.reset()
+	r.profile.reset()
+}
+
+// regoOptions returns the options shared by queries, what-if queries and partial evaluations, so that
+// they all run with the same custom builtins, imports and unsafe builtins.
+func (r *Runtime) regoOptions(txn storage.Transaction, parsedQuery ast.Body, m metrics.Metrics) []func(*rego.Rego) {
+	opts := slices.Clone(r.builtins)
+
+	if r.capabilities != nil {
+		opts = append(opts, rego.Capabilities(r.capabilities))
+	}
+
+	return append(opts,
+		rego.Store(r.storage),
+		rego.Transaction(txn),
+		rego.ParsedQuery(parsedQuery),
+		rego.Metrics(m),
+		rego.Runtime(r.pluginsManager.Info),
+		rego.UnsafeBuiltins(r.unsafeBuiltins),
+		rego.Imports(r.imports),
+		rego.EnablePrintStatements(r.printStatements),
+	)
+}
+
+// preparedQuery returns a prepared query for the given parsed query, preparing and caching it if needed.
+func (r *Runtime) preparedQuery(
+	ctx context.Context,
+	txn storage.Transaction,
+	parsedQuery ast.Body,
+	m metrics.Metrics,
+) (rego.PreparedEvalQuery, error) {
+	compiler := r.pluginsManager.GetCompiler()
+
+	key := parsedQuery.String()
+
+	if pq, ok := r.preparedQueries.get(key, compiler); ok {
+		m.Counter(MetricQueryCacheHit).Incr()
+		return pq, nil
+	}
+
+	m.Counter(MetricQueryCacheMiss).Incr()
+
+	// rego.Trace is not set: a prepared query shares its trace buffer with every evaluation, where it would grow
+	// without bounds. Explanations are collected by a buffer tracer per evaluation instead, see newQueryTracers.
+	opts := append(r.regoOptions(txn, parsedQuery, m), rego.Compiler(compiler))
+
+	pq, err := rego.New(opts...).PrepareForEval(ctx)
+	if err != nil {
+		return rego.PreparedEvalQuery{}, err
+	}
+
+	r.preparedQueries.put(key, compiler, pq)
+
+	return pq, nil
+}
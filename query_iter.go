@@ -0,0 +1,448 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/plugins/logs"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/topdown"
+	"github.com/open-policy-agent/opa/v1/types"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var errStopIteration = errors.New("iteration stopped")
+
+// IterResult is a single solution of a query evaluated by QueryIter.
+type IterResult struct {
+	rego.Result
+
+	// Prints holds the output of the print() calls made since the previous solution,
+	// if print() output is returned to the caller, see WithPrintOutput.
+	Prints []Print
+}
+
+// QueryIter evaluates a query and yields each solution as soon as topdown produces it,
+// instead of collecting them in a rego.ResultSet. Breaking out of the loop stops the evaluation.
+// An error, if any, is yielded last. Unlike Query, solutions are not deduplicated, and results
+// are neither cached nor explained. Decision logs and recordings hold the solutions that were yielded.
+//
+//	for result, err := range r.QueryIter(ctx, "data.resources[x]", nil) {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (r *Runtime) QueryIter(
+	ctx context.Context,
+	qStr string,
+	input map[string]any,
+	opts ...QueryOption,
+) iter.Seq2[IterResult, error] {
+	return func(yield func(IterResult, error) bool) {
+		qo := r.queryOptions(opts)
+		ctx, decisionID := r.decisionContext(ctx, qo)
+
+		ctx, span := r.startSpan(ctx, "runtime.QueryIter", attribute.String(attrDecisionID, decisionID), attribute.String(attrQuery, qStr))
+		defer span.End()
+
+		parsedQuery, err := r.ValidateQuery(qStr)
+		if err != nil {
+			yield(IterResult{}, spanError(span, errors.Wrap(err, "failed to validate query")))
+			return
+		}
+
+		release, err := r.admit(ctx)
+		if err != nil {
+			yield(IterResult{}, spanError(span, err))
+			return
+		}
+
+		defer release()
+
+		txn, err := r.storage.NewTransaction(ctx)
+		if err != nil {
+			yield(IterResult{}, spanError(span, errors.Wrap(err, "failed to create new OPA store transaction")))
+			return
+		}
+
+		defer r.storage.Abort(ctx, txn)
+
+		r.setSpanRevisions(ctx, span, txn)
+
+		if err := r.execQueryIter(ctx, txn, decisionID, parsedQuery, input, qo, yield); err != nil {
+			yield(IterResult{}, spanError(span, evalError(decisionID, qStr, err)))
+		}
+	}
+}
+
+// execQueryIter is the streaming counterpart of execQuery.
+func (r *Runtime) execQueryIter(
+	ctx context.Context,
+	txn storage.Transaction,
+	decisionID string,
+	parsedQuery ast.Body,
+	input map[string]any,
+	qo *queryOptions,
+	yield func(IterResult, error) bool,
+) error {
+	timestamp := time.Now().UTC()
+	m := metrics.New()
+	recording := r.recording(decisionID, parsedQuery, input, qo)
+
+	// the solutions are only kept when they are logged or recorded.
+	var output rego.ResultSet
+
+	keep := recording != nil || r.decisionLogger != nil || logs.Lookup(r.pluginsManager) != nil
+
+	err := r.iterQuery(ctx, txn, decisionID, parsedQuery, input, m, recording, qo, func(result IterResult) bool {
+		if keep {
+			output = append(output, result.Result)
+		}
+
+		return yield(result, nil)
+	})
+	r.metrics.observeQuery("query_iter", parsedQuery, time.Since(timestamp), err)
+
+	if err == nil && recording != nil {
+		r.record(ctx, txn, recording, output)
+	}
+
+	r.logDecision(ctx, &decision{
+		txn:        txn,
+		decisionID: decisionID,
+		query:      parsedQuery.String(),
+		input:      input,
+		results:    output,
+		metrics:    m,
+		metadata:   DecisionMetadataFromContext(ctx),
+		err:        err,
+		timestamp:  timestamp,
+	})
+
+	return err
+}
+
+func (r *Runtime) iterQuery(
+	ctx context.Context,
+	txn storage.Transaction,
+	decisionID string,
+	parsedQuery ast.Body,
+	input map[string]any,
+	m metrics.Metrics,
+	recording *Recording,
+	qo *queryOptions,
+	yield func(IterResult) bool,
+) error {
+	if err := r.checkQuery(ctx, decisionID, parsedQuery, input, qo); err != nil {
+		return err
+	}
+
+	budget := newEvalBudget(ctx, qo.limits)
+	defer budget.release()
+
+	ctx = budget.ctx
+
+	cq, err := r.compiledQuery(parsedQuery, m)
+	if err != nil {
+		return errors.Wrap(compileError(err), "failed to compile rego query")
+	}
+
+	hook := r.printHook(qo)
+	tracers := r.newQueryTracers(false, budget)
+
+	q, err := r.topdownQuery(txn, cq, input, m, hook, recording)
+	if err != nil {
+		return err
+	}
+
+	for _, tracer := range tracers.list() {
+		q = q.WithQueryTracer(tracer)
+	}
+
+	cancel := topdown.NewCancel()
+	stop := context.AfterFunc(ctx, cancel.Cancel)
+
+	defer stop()
+
+	stopped := false
+
+	err = q.WithCancel(cancel).Iter(ctx, func(qr topdown.QueryResult) error {
+		result, err := cq.result(qr)
+		if err != nil {
+			return err
+		}
+
+		if !yield(IterResult{Result: result, Prints: hook.drain()}) {
+			stopped = true
+			return errStopIteration
+		}
+
+		return nil
+	})
+	r.recordTracers(tracers)
+
+	if stopped {
+		return nil
+	}
+
+	if budgetErr := budget.err(decisionID); budgetErr != nil {
+		return budgetErr
+	}
+
+	return cancelError(err)
+}
+
+// topdownQuery returns the topdown query evaluating cq, with the same options as the prepared queries of Query.
+func (r *Runtime) topdownQuery(
+	txn storage.Transaction,
+	cq *compiledQuery,
+	input map[string]any,
+	m metrics.Metrics,
+	hook *printHook,
+	recording *Recording,
+) (*topdown.Query, error) {
+	q := topdown.NewQuery(cq.query).
+		WithQueryCompiler(cq.qc).
+		WithCompiler(cq.compiler).
+		WithStore(r.storage).
+		WithTransaction(txn).
+		WithMetrics(m).
+		WithRuntime(r.pluginsManager.Info).
+		WithInterQueryBuiltinCache(r.InterQueryCache)
+
+	if input != nil {
+		v, err := ast.InterfaceToValue(input)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to convert input")
+		}
+
+		q = q.WithInput(ast.NewTerm(v))
+	}
+
+	if recording != nil {
+		q = q.WithTime(recording.EvalTime).WithNDBuiltinCache(recording.NDBuiltinCache)
+	}
+
+	if hook != nil {
+		q = q.WithPrintHook(hook)
+	}
+
+	for _, resolver := range r.pluginsManager.GetWasmResolvers() {
+		for _, entrypoint := range resolver.Entrypoints() {
+			q = q.WithResolver(entrypoint, resolver)
+		}
+	}
+
+	return q, nil
+}
+
+// compiledQuery is a query compiled for QueryIter. Like rego does for Query, the query is rewritten to
+// capture the value of its expressions, so that solutions hold expression values as well as bindings.
+type compiledQuery struct {
+	compiler *ast.Compiler
+	qc       ast.QueryCompiler
+	query    ast.Body
+	// expressions are the expressions of the query solutions report a value for, in order.
+	expressions []queryExpression
+}
+
+// queryExpression is an expression of a compiled query, and the variable its value is captured in.
+// Expressions without a captured value are true in every solution.
+type queryExpression struct {
+	value   *rego.ExpressionValue
+	capture ast.Var
+}
+
+// compiledQuery returns the compiled query for the given parsed query, compiling and caching it if needed.
+func (r *Runtime) compiledQuery(parsedQuery ast.Body, m metrics.Metrics) (*compiledQuery, error) {
+	compiler := r.pluginsManager.GetCompiler()
+
+	key := parsedQuery.String()
+
+	if cq, ok := r.compiledQueries.get(key, compiler); ok {
+		m.Counter(MetricQueryCacheHit).Incr()
+		return cq, nil
+	}
+
+	m.Counter(MetricQueryCacheMiss).Incr()
+
+	imports, err := ast.ParseImports(importStatements(r.imports))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid imports")
+	}
+
+	cq := &compiledQuery{compiler: compiler}
+	cq.qc = compiler.QueryCompiler().
+		WithContext(ast.NewQueryContext().WithImports(imports)).
+		WithUnsafeBuiltins(r.unsafeBuiltins).
+		WithEnablePrintStatements(r.printStatements).
+		WithStageAfter("ResolveRefs", ast.QueryCompilerStageDefinition{
+			Name:       "RewriteToCaptureValue",
+			MetricName: "query_compile_stage_rewrite_to_capture_value",
+			Stage:      cq.captureValues,
+		})
+
+	m.Timer(metrics.RegoQueryCompile).Start()
+	cq.query, err = cq.qc.Compile(parsedQuery)
+	m.Timer(metrics.RegoQueryCompile).Stop()
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.compiledQueries.put(key, compiler, cq)
+
+	return cq, nil
+}
+
+// captureValues rewrites the expressions of a query that are not assignments or unifications, e.g. `data.x[y]`
+// or `neq(1, 2)`, to capture their value, as rego does. If the query iterates or has more than one expression,
+// each captured value is also checked, so that solutions where it is false are skipped. The expressions are
+// recorded with the name of their capture variable, so that later stages may rewrite them.
+func (cq *compiledQuery) captureValues(_ ast.QueryCompiler, query ast.Body) (ast.Body, error) {
+	check := iterates(query) || len(query) > 1
+	captured := 0
+
+	for _, expr := range query {
+		if expr.Generated {
+			continue
+		}
+
+		qe := queryExpression{value: expressionValue(expr)}
+		cq.expressions = append(cq.expressions, qe)
+
+		if expr.Negated || expr.IsAssignment() || expr.IsEquality() {
+			continue
+		}
+
+		capture := ast.VarTerm(fmt.Sprintf("%sterm%d", ast.WildcardPrefix, captured+1))
+
+		switch terms := expr.Terms.(type) {
+		case *ast.Term:
+			expr.Terms = ast.Equality.Expr(terms, capture).Terms
+		case []*ast.Term:
+			tpe := cq.compiler.TypeEnv.GetByValue(terms[0].Value)
+			if types.Void(tpe) || types.Arity(tpe) != len(terms)-1 {
+				continue
+			}
+
+			expr.Terms = append(terms, capture)
+		default:
+			continue
+		}
+
+		captured++
+		cq.expressions[len(cq.expressions)-1].capture = capture.Value.(ast.Var)
+
+		if check {
+			cpy := expr.Copy()
+			cpy.Terms = capture
+			cpy.Generated = true
+			cpy.With = nil
+			query.Append(cpy)
+		}
+	}
+
+	return query, nil
+}
+
+// result converts a solution of the query, like rego does for Query.
+func (cq *compiledQuery) result(qr topdown.QueryResult) (rego.Result, error) {
+	rewritten := cq.qc.RewrittenVars()
+	result := rego.Result{Bindings: rego.Vars{}}
+
+	for k, term := range qr {
+		if rw, ok := rewritten[k]; ok {
+			k = rw
+		}
+
+		if k.IsGenerated() || k.IsWildcard() {
+			continue
+		}
+
+		v, err := ast.JSON(term.Value)
+		if err != nil {
+			return result, err
+		}
+
+		result.Bindings[string(k)] = v
+	}
+
+	for _, qe := range cq.expressions {
+		ev := *qe.value
+		ev.Value = true
+
+		if qe.capture != "" {
+			term, ok := qr[qe.capture]
+			if !ok {
+				return result, errors.Errorf("no value captured for expression [%s]", ev.Text)
+			}
+
+			v, err := ast.JSON(term.Value)
+			if err != nil {
+				return result, err
+			}
+
+			ev.Value = v
+		}
+
+		result.Expressions = append(result.Expressions, &ev)
+	}
+
+	return result, nil
+}
+
+func expressionValue(expr *ast.Expr) *rego.ExpressionValue {
+	ev := &rego.ExpressionValue{}
+
+	if expr.Location != nil {
+		ev.Text = string(expr.Location.Text)
+		ev.Location = &rego.Location{Row: expr.Location.Row, Col: expr.Location.Col}
+	}
+
+	return ev
+}
+
+// iterates returns true if a query can have more than one solution, i.e. if it calls a relation
+// or contains a reference with a variable outside of comprehensions.
+func iterates(query ast.Body) bool {
+	found := false
+
+	ast.NewGenericVisitor(func(x any) bool {
+		switch x := x.(type) {
+		case *ast.Term:
+			return ast.IsComprehension(x.Value)
+		case ast.Ref:
+			if bi := ast.BuiltinMap[x.String()]; bi != nil && bi.Relation {
+				found = true
+			}
+
+			for _, term := range x[1:] {
+				if _, ok := term.Value.(ast.Var); ok {
+					found = true
+				}
+			}
+		}
+
+		return found
+	}).Walk(query)
+
+	return found
+}
+
+func importStatements(imports []string) string {
+	s := make([]string, len(imports))
+	for i, imp := range imports {
+		s[i] = "import " + imp
+	}
+
+	return strings.Join(s, "\n")
+}
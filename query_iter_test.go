@@ -0,0 +1,161 @@
+package runtime_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryIter(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	// Act
+	values := []any{}
+
+	for result, err := range r.QueryIter(ctx, "x := numbers.range(1, 100000)[_]; x > input.min", map[string]any{"min": 10}) {
+		assert.NoError(err)
+
+		values = append(values, result.Bindings["x"])
+		if len(values) == 3 {
+			break
+		}
+	}
+
+	var iterErr error
+	for _, err := range r.QueryIter(ctx, "data.simple[", nil) {
+		iterErr = err
+	}
+
+	bundles, bundlesErr := r.GetBundles(ctx)
+
+	// Assert
+	assert.Equal([]any{json.Number("11"), json.Number("12"), json.Number("13")}, values)
+	assert.ErrorContains(iterErr, "failed to validate query")
+	assert.NoError(bundlesErr)
+	assert.Len(bundles, 1)
+}
+
+func TestQueryIterLimits(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{}, runtime.WithEvalLimits(runtime.EvalLimits{MaxSteps: 1000}))
+	assert.NoError(err)
+
+	// Act
+	var iterErr error
+	for _, err := range r.QueryIter(ctx, "x := numbers.range(1, 1000)[_]", nil) {
+		iterErr = err
+	}
+
+	// Assert
+	assert.ErrorIs(iterErr, runtime.ErrEvalBudgetExceeded)
+}
+
+func TestQueryIterResults(t *testing.T) {
+	queries := []string{
+		"data.simple.allowed",
+		"[1, 2, 3][x] > 1",
+		"x := [1, 2][_]; y = x * 2",
+		"[false, true][x]",
+		// bare calls
+		"neq(1, 2)",
+		"count([1, 2])",
+		"plus(1, 2, x)",
+		"numbers.range(1, 3)[_]",
+		// negation
+		"not data.simple.allowed",
+		"x := [1, 2, 3][_]; not x == 2",
+		// comprehensions
+		"[x | x := [1, 2, 3][_]; x > 1]",
+		"count({x | some x in [1, 2, 2]}) == 2",
+		"y := {k: v | some k, v in {\"a\": 1}}",
+		// with
+		"data.simple.allowed with data.simple.allowed as true",
+		"x = input.a with input as {\"a\": 1}",
+		// multiple expressions
+		"x := 1; x > 0; neq(x, 2)",
+		"true; false",
+		"x := [1, 2][_]; x; count([x]) == 1",
+	}
+
+	for _, query := range queries {
+		t.Run(query, func(t *testing.T) {
+			// Arrange
+			assert := require.New(t)
+			ctx := t.Context()
+
+			r, err := runtime.New(ctx, &runtime.Config{
+				LocalBundles: runtime.LocalBundlesConfig{
+					Paths: []string{testutil.AssetSimpleBundle()},
+				},
+			})
+			assert.NoError(err)
+
+			// Act
+			var results rego.ResultSet
+
+			for result, err := range r.QueryIter(ctx, query, nil) {
+				assert.NoError(err)
+
+				results = append(results, result.Result)
+			}
+
+			expected, err := r.Query(ctx, query, nil, false, false, false, types.ExplainOffV1)
+
+			// Assert
+			assert.NoError(err)
+			assert.Equal(expected.Result, results)
+		})
+	}
+}
+
+func TestQueryIterPrintsAndDecisionLogs(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	var buf bytes.Buffer
+
+	r, err := runtime.New(ctx, &runtime.Config{},
+		runtime.WithPrintStatements(runtime.PrintToLogger),
+		runtime.WithDecisionLogger(runtime.NewWriterDecisionLogger(&buf)))
+	assert.NoError(err)
+
+	// Act
+	prints := [][]runtime.Print{}
+
+	for result, err := range r.QueryIter(ctx, "x := [1, 2, 3][_]; print(x)", nil, runtime.WithPrintOutput(runtime.PrintToResult)) {
+		assert.NoError(err)
+
+		prints = append(prints, result.Prints)
+		if len(prints) == 2 {
+			break
+		}
+	}
+
+	// Assert
+	assert.Len(prints, 2)
+	assert.Equal("1", prints[0][0].Message)
+	assert.Equal("2", prints[1][0].Message)
+
+	var event map[string]any
+	assert.NoError(json.Unmarshal(buf.Bytes(), &event))
+	assert.Len(event["result"], 2)
+}
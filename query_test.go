@@ -0,0 +1,161 @@
+package runtime_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	opatypes "github.com/open-policy-agent/opa/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryCache(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	query := func() map[string]any {
+		result, err := r.Query(ctx, "data.simple.allowed", nil, false, true, false, types.ExplainOffV1)
+		assert.NoError(err)
+		assert.Len(result.Result, 1)
+		assert.Equal(false, result.Result[0].Expressions[0].Value)
+
+		return result.Metrics
+	}
+
+	// Act & Assert
+	assert.Contains(query(), "counter_"+runtime.MetricQueryCacheMiss)
+	assert.Contains(query(), "counter_"+runtime.MetricQueryCacheHit)
+
+	// installing a new policy replaces the compiler and invalidates the cache.
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "extra.rego", []byte("package extra\n\nx := 1\n"))
+	})
+	assert.NoError(err)
+
+	assert.Contains(query(), "counter_"+runtime.MetricQueryCacheMiss)
+	assert.Contains(query(), "counter_"+runtime.MetricQueryCacheHit)
+}
+
+func TestQueryBatch(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	}, runtime.WithBatchWorkers(2))
+	assert.NoError(err)
+
+	queries := []runtime.BatchQuery{
+		{Query: "data.simple.allowed"},
+		{Query: "x = input.n", Input: map[string]any{"n": 1}},
+		{Query: "data.simple["},
+		{Query: "x = input.n", Input: map[string]any{"n": 2}},
+	}
+
+	// Act
+	results, err := r.QueryBatch(ctx, queries)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(results, len(queries))
+
+	assert.NoError(results[0].Err)
+	assert.Equal(false, results[0].Result.Result[0].Expressions[0].Value)
+
+	assert.NoError(results[1].Err)
+	assert.Equal(json.Number("1"), results[1].Result.Result[0].Bindings["x"])
+
+	assert.Error(results[2].Err)
+	assert.Nil(results[2].Result)
+
+	assert.NoError(results[3].Err)
+	assert.Equal(json.Number("2"), results[3].Result.Result[0].Bindings["x"])
+}
+
+func TestQueryBatchSnapshot(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	var r *runtime.Runtime
+
+	written := make(chan struct{})
+	committed := make(chan error, 1)
+
+	// test.write writes a new counter to the store, and returns once the write is pending.
+	// Its commit has to wait for the batch's read transaction to end.
+	writeBuiltin := runtime.WithBuiltinDyn(&rego.Function{
+		Name: "test.write",
+		Decl: opatypes.NewFunction(nil, opatypes.B),
+	}, func(_ rego.BuiltinContext, _ []*ast.Term) (*ast.Term, error) {
+		go func() {
+			store := r.GetPluginsManager().Store
+
+			txn, err := store.NewTransaction(ctx, storage.WriteParams)
+			if err != nil {
+				committed <- err
+				return
+			}
+
+			err = store.Write(ctx, txn, storage.ReplaceOp, storage.MustParsePath("/batch/counter"), 2)
+			close(written)
+
+			if err != nil {
+				store.Abort(ctx, txn)
+				committed <- err
+
+				return
+			}
+
+			committed <- store.Commit(ctx, txn)
+		}()
+
+		<-written
+
+		return ast.BooleanTerm(true), nil
+	})
+
+	r, err := runtime.New(ctx, &runtime.Config{}, writeBuiltin, runtime.WithBatchWorkers(4))
+	assert.NoError(err)
+
+	err = storage.WriteOne(ctx, r.GetPluginsManager().Store, storage.AddOp, storage.MustParsePath("/batch"),
+		map[string]any{"counter": 1})
+	assert.NoError(err)
+
+	queries := []runtime.BatchQuery{{Query: "test.write(); x = data.batch.counter"}}
+	for range 32 {
+		queries = append(queries, runtime.BatchQuery{Query: "x = data.batch.counter"})
+	}
+
+	// Act
+	results, err := r.QueryBatch(ctx, queries)
+
+	// Assert
+	assert.NoError(err)
+	assert.NoError(<-committed)
+
+	for _, result := range results {
+		assert.NoError(result.Err)
+		assert.Equal(json.Number("1"), result.Result.Result[0].Bindings["x"])
+	}
+
+	after, err := r.Query(ctx, "x = data.batch.counter", nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+	assert.Equal(json.Number("2"), after.Result[0].Bindings["x"])
+}
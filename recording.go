@@ -0,0 +1,277 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
+	"github.com/open-policy-agent/opa/v1/topdown/builtins"
+	"github.com/open-policy-agent/opa/v1/util"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// Recording holds everything needed to evaluate a decision again, see WithRecorder and Replay.
+type Recording struct {
+	DecisionID string         `json:"decision_id"`
+	Query      string         `json:"query"`
+	Input      map[string]any `json:"input,omitempty"`
+	// Revisions are the revisions of the bundles the decision was evaluated against, by bundle name.
+	Revisions map[string]string `json:"bundle_revisions,omitempty"`
+	// NDBuiltinCache holds the results of the non-deterministic builtins (e.g. http.send or time.now_ns) called
+	// during the evaluation.
+	NDBuiltinCache builtins.NDBCache `json:"nd_builtin_cache,omitempty"`
+	EvalTime       time.Time         `json:"eval_time"`
+	Metadata       map[string]any    `json:"metadata,omitempty"`
+	Result         rego.ResultSet    `json:"result"`
+}
+
+// UnmarshalJSON restores the arguments of the non-deterministic builtin calls, which builtins.NDBCache encodes as strings.
+func (rec *Recording) UnmarshalJSON(data []byte) error {
+	type recording Recording
+
+	if err := util.UnmarshalJSON(data, (*recording)(rec)); err != nil {
+		return err
+	}
+
+	for name, calls := range rec.NDBuiltinCache {
+		restored := ast.NewObject()
+
+		err := calls.Iter(func(k, v *ast.Term) error {
+			if s, ok := k.Value.(ast.String); ok {
+				args, err := ast.ParseTerm(string(s))
+				if err != nil {
+					return errors.Wrapf(err, "invalid arguments of builtin call [%s]", name)
+				}
+
+				k = args
+			}
+
+			restored.Insert(k, v)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		rec.NDBuiltinCache[name] = restored
+	}
+
+	return nil
+}
+
+// Recorder stores the recordings of selected queries.
+type Recorder interface {
+	Record(ctx context.Context, recording *Recording) error
+}
+
+// RecordSelector returns true if the evaluation of query with input must be recorded.
+type RecordSelector func(query string, input map[string]any) bool
+
+// FileRecorder writes every recording to a JSON file named after its decision ID in Dir.
+type FileRecorder struct {
+	Dir string
+}
+
+func (f *FileRecorder) Record(_ context.Context, recording *Recording) error {
+	bs, err := json.MarshalIndent(recording, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal recording")
+	}
+
+	path := filepath.Join(f.Dir, filepath.Base(recording.DecisionID)+".json")
+	if err := os.WriteFile(path, bs, 0o600); err != nil {
+		return errors.Wrapf(err, "failed to write recording [%s]", path)
+	}
+
+	return nil
+}
+
+// ReadRecording reads a recording from a JSON file, e.g. one written by FileRecorder.
+func ReadRecording(path string) (*Recording, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read recording [%s]", path)
+	}
+
+	recording := &Recording{}
+	if err := util.UnmarshalJSON(bs, recording); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal recording [%s]", path)
+	}
+
+	return recording, nil
+}
+
+// ReplayResult is the outcome of replaying a recording.
+type ReplayResult struct {
+	Result rego.ResultSet
+	// Match is true if the result equals the recorded result.
+	Match bool
+	// Revision is the revision of the bundle the recording was replayed against.
+	Revision string
+	// RevisionMatch is true if the decision was recorded against a bundle with the same revision.
+	RevisionMatch bool
+}
+
+// recording returns a recording of the evaluation, to be completed once it succeeds, or nil if it is not recorded.
+func (r *Runtime) recording(decisionID string, parsedQuery ast.Body, input map[string]any, qo *queryOptions) *Recording {
+	if r.recorder == nil || len(qo.modules) > 0 {
+		return nil
+	}
+
+	query := parsedQuery.String()
+	if !qo.record && (r.recordSelector == nil || !r.recordSelector(query, input)) {
+		return nil
+	}
+
+	return &Recording{
+		DecisionID:     decisionID,
+		Query:          query,
+		Input:          input,
+		NDBuiltinCache: builtins.NDBCache{},
+		EvalTime:       time.Now().UTC(),
+	}
+}
+
+func (r *Runtime) record(ctx context.Context, txn storage.Transaction, recording *Recording, result rego.ResultSet) {
+	recording.Revisions = r.bundleRevisions(ctx, txn)
+	recording.Metadata = DecisionMetadataFromContext(ctx)
+	recording.Result = result
+
+	if err := r.recorder.Record(ctx, recording); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("failed to record decision")
+	}
+}
+
+// Replay evaluates a recording again against the bundle at bundlePath, with the recorded input, evaluation time and
+// results of non-deterministic builtins, and reports whether the result matches. The bundle is loaded into a store
+// and compiler of its own, so the policies and data of the runtime are neither used nor modified.
+func (r *Runtime) Replay(ctx context.Context, recording *Recording, bundlePath string) (*ReplayResult, error) {
+	parsedQuery, err := r.ValidateQuery(recording.Query)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate query")
+	}
+
+	b, err := r.loadBundle(bundlePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load bundle from local path '%s'", bundlePath)
+	}
+
+	store := inmem.New()
+
+	txn, err := store.NewTransaction(ctx, storage.WriteParams)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create new OPA store transaction")
+	}
+
+	defer store.Abort(ctx, txn)
+
+	compiler := ast.NewCompiler().SetErrorLimit(r.Config.PluginsErrorLimit)
+	r.restrictCompiler(compiler)
+
+	err = bundle.Activate(&bundle.ActivateOpts{
+		Ctx:      ctx,
+		Store:    store,
+		Txn:      txn,
+		Compiler: compiler,
+		Metrics:  metrics.New(),
+		Bundles:  map[string]*bundle.Bundle{bundlePath: b},
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to activate bundle [%s]", bundlePath)
+	}
+
+	if len(r.unsafeBuiltins) > 0 {
+		if err := r.checkPolicies(compiler.Modules); err != nil {
+			return nil, errors.Wrapf(err, "failed to activate bundle [%s]", bundlePath)
+		}
+	}
+
+	opts := append(r.regoOptions(txn, parsedQuery, metrics.New()), rego.Store(store), rego.Compiler(compiler))
+
+	pq, err := rego.New(opts...).PrepareForEval(ctx)
+	if err != nil {
+		return nil, errors.Wrap(compileError(err), "failed to prepare rego query")
+	}
+
+	// copied, so builtin calls that were not recorded don't modify the recording.
+	ndbc := make(builtins.NDBCache, len(recording.NDBuiltinCache))
+	for name, calls := range recording.NDBuiltinCache {
+		ndbc[name] = calls.Copy()
+	}
+
+	output, err := pq.Eval(ctx,
+		rego.EvalTransaction(txn),
+		rego.EvalInput(recording.Input),
+		rego.EvalTime(recording.EvalTime),
+		rego.EvalNDBuiltinCache(ndbc),
+	)
+	if err != nil {
+		return nil, evalError(recording.DecisionID, recording.Query, cancelError(err))
+	}
+
+	match, err := equalResults(output, recording.Result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReplayResult{
+		Result:        output,
+		Match:         match,
+		Revision:      b.Manifest.Revision,
+		RevisionMatch: slices.Contains(slices.Collect(maps.Values(recording.Revisions)), b.Manifest.Revision),
+	}, nil
+}
+
+// equalResults compares the JSON representation of the values and bindings of two result sets,
+// so a result set read from a file equals the one it was written from.
+func equalResults(a, b rego.ResultSet) (bool, error) {
+	normalize := func(rs rego.ResultSet) (any, error) {
+		results := make([]map[string]any, len(rs))
+
+		for i, result := range rs {
+			values := make([]any, len(result.Expressions))
+			for j, expr := range result.Expressions {
+				values[j] = expr.Value
+			}
+
+			results[i] = map[string]any{"expressions": values, "bindings": result.Bindings}
+		}
+
+		bs, err := json.Marshal(results)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal result")
+		}
+
+		var v any
+		if err := util.UnmarshalJSON(bs, &v); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal result")
+		}
+
+		return v, nil
+	}
+
+	va, err := normalize(a)
+	if err != nil {
+		return false, err
+	}
+
+	vb, err := normalize(b)
+	if err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(va, vb), nil
+}
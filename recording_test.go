@@ -0,0 +1,86 @@
+package runtime_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	}, runtime.WithRecorder(&runtime.FileRecorder{Dir: dir}, nil))
+	assert.NoError(err)
+
+	query := `x = data.simple.allowed; now = time.now_ns(); id = uuid.rfc4122(input.user); users = object.get(data, "users", {})`
+	input := map[string]any{"user": "alice"}
+
+	// Act
+	result, err := r.Query(ctx, query, input, false, false, false, types.ExplainOffV1, runtime.WithRecording())
+	assert.NoError(err)
+
+	_, err = r.Query(ctx, "x = data.simple.allowed", input, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	assert.NoError(err)
+
+	recording, err := runtime.ReadRecording(filepath.Join(dir, result.DecisionID+".json"))
+	assert.NoError(err)
+
+	replay, err := r.Replay(ctx, recording, testutil.AssetSimpleBundle())
+	assert.NoError(err)
+
+	// the runtime's own data is not used by a replay.
+	err = storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.Write(ctx, txn, storage.AddOp, storage.MustParsePath("/users"), map[string]any{"alice": true})
+	})
+	assert.NoError(err)
+
+	unchanged, err := r.Replay(ctx, recording, testutil.AssetSimpleBundle())
+	assert.NoError(err)
+
+	changedBundle := t.TempDir()
+	assert.NoError(os.WriteFile(filepath.Join(changedBundle, "hello.rego"), []byte("package simple\n\nallowed := true\n"), 0o600))
+	assert.NoError(os.WriteFile(filepath.Join(changedBundle, ".manifest"), []byte(`{"revision": "changed"}`), 0o600))
+
+	changed, err := r.Replay(ctx, recording, changedBundle)
+	assert.NoError(err)
+
+	// Assert
+	// only the selected query is recorded.
+	assert.Len(files, 1)
+	assert.Equal(query, recording.Query)
+	assert.Equal("alice", recording.Input["user"])
+	assert.Contains(recording.NDBuiltinCache, "time.now_ns")
+	assert.Contains(recording.NDBuiltinCache, "uuid.rfc4122")
+
+	assert.True(replay.Match)
+	assert.True(replay.RevisionMatch)
+	assert.Equal(result.Result[0].Bindings["now"], replay.Result[0].Bindings["now"])
+	assert.Equal(result.Result[0].Bindings["id"], replay.Result[0].Bindings["id"])
+
+	assert.True(unchanged.Match)
+
+	assert.False(changed.Match)
+	assert.False(changed.RevisionMatch)
+	assert.Equal("changed", changed.Revision)
+	assert.Equal(true, changed.Result[0].Bindings["x"])
+
+	allowed, err := r.Query(ctx, "x = data.simple.allowed", nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+	assert.Equal(false, allowed.Result[0].Bindings["x"])
+}
@@ -6,6 +6,7 @@ import (
 	"maps"
 	"os"
 	"path/filepath"
+	goruntime "runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -28,8 +29,12 @@ import (
 	"github.com/open-policy-agent/opa/v1/version"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // Runtime manages the OPA runtime (plugins, store and info data).
@@ -50,6 +55,13 @@ type Runtime struct {
 	compilerBuiltins map[string]*ast.Builtin
 	imports          []string
 
+	builtinsPartialEval map[string]PartialEval
+	// partialEvalUnknowns are the builtins whose calls Compile keeps, and partialEvalNondeterministic is set
+	// if it evaluates nondeterministic builtins, see setupPartialEval.
+	partialEvalUnknowns         []string
+	partialEvalNondeterministic bool
+	cacheableBuiltins           map[string]struct{}
+
 	pluginStates                *sync.Map
 	bundleStates                *sync.Map
 	bundlesCallbackRegistered   atomic.Bool
@@ -58,6 +70,45 @@ type Runtime struct {
 	storage     storage.Store
 	latestState atomic.Pointer[State]
 	regoVersion ast.RegoVersion
+
+	preparedQueries *preparedQueryCache[rego.PreparedEvalQuery]
+	compiledQueries *preparedQueryCache[*compiledQuery]
+	batchWorkers    int
+	evalLimits      EvalLimits
+
+	unsafeBuiltins       map[string]struct{}
+	httpSendAllowedHosts map[string]struct{}
+	capabilities         *ast.Capabilities
+
+	decisionLogger DecisionLogger
+	maskDecision   ast.Ref
+	maskQuery      atomic.Pointer[preparedQueryEntry[rego.PreparedEvalQuery]]
+
+	inputSchemaDefs   map[string]any
+	inputSchemas      map[string]ast.Value
+	annotatedSchemas  bool
+	entrypointSchemas sync.Map
+	schemaCache       cache.InterQueryValueCache
+
+	coverage *coverage
+	profile  *profile
+
+	decisionCache *decisionCache
+
+	printStatements bool
+	printOutput     PrintOutput
+
+	metricsRegisterer prometheus.Registerer
+	metrics           *runtimeMetrics
+
+	tracer trace.Tracer
+
+	recorder       Recorder
+	recordSelector RecordSelector
+
+	admission *admission
+
+	wasmEvaluation bool
 }
 
 type BundleState struct {
@@ -92,10 +143,22 @@ func New(ctx context.Context, cfg *Config, opts ...Option) (*Runtime, error) {
 		builtins:         []func(*rego.Rego){},
 		compilerBuiltins: map[string]*ast.Builtin{},
 
+		builtinsPartialEval: map[string]PartialEval{},
+		cacheableBuiltins:   map[string]struct{}{},
+
 		pluginStates: &sync.Map{},
 		bundleStates: &sync.Map{},
 		plugins:      map[string]plugins.Factory{},
 		regoVersion:  DefaultRegoVersion.ToAstRegoVersion(),
+
+		preparedQueries: newPreparedQueryCache[rego.PreparedEvalQuery](defaultQueryCacheSize),
+		compiledQueries: newPreparedQueryCache[*compiledQuery](defaultQueryCacheSize),
+		batchWorkers:    goruntime.GOMAXPROCS(0),
+
+		inputSchemaDefs: map[string]any{},
+		inputSchemas:    map[string]ast.Value{},
+
+		tracer: noop.NewTracerProvider().Tracer(tracerName),
 	}
 
 	runtime.latestState.Store(&State{})
@@ -108,6 +171,24 @@ func New(ctx context.Context, cfg *Config, opts ...Option) (*Runtime, error) {
 		runtime.storage = inmem.New()
 	}
 
+	if runtime.wasmEvaluation && !wasmEngineAvailable {
+		return nil, errors.New("wasm evaluation requires building with the opa_wasm tag")
+	}
+
+	runtime.setupUnsafeBuiltins()
+
+	if err := runtime.setupInputSchemas(); err != nil {
+		return nil, err
+	}
+
+	if err := runtime.setupMaskDecision(); err != nil {
+		return nil, err
+	}
+
+	if err := runtime.setupMetrics(); err != nil {
+		return nil, err
+	}
+
 	runtime.registerBuiltins()
 
 	if pm, err := runtime.newOPAPluginsManager(ctx); err != nil {
@@ -116,7 +197,12 @@ func New(ctx context.Context, cfg *Config, opts ...Option) (*Runtime, error) {
 		runtime.pluginsManager = pm
 	}
 
-	runtime.InterQueryCache = cache.NewInterQueryCache(runtime.pluginsManager.InterQueryBuiltinCacheConfig())
+	runtime.InterQueryCache = runtime.metrics.instrumentCache(cache.NewInterQueryCache(runtime.pluginsManager.InterQueryBuiltinCacheConfig()))
+	runtime.schemaCache = cache.NewInterQueryValueCache(ctx, runtime.pluginsManager.InterQueryBuiltinCacheConfig())
+
+	if err := runtime.registerDecisionCacheTrigger(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to register decision cache trigger")
+	}
 
 	if err := runtime.registerDiscovery(); err != nil {
 		return nil, err
@@ -211,28 +297,60 @@ func (r *Runtime) registerBuiltins() {
 
 	for decl, impl := range r.builtins1 {
 		r.Logger.Info().Str("name", decl.Name).Msg("registering builtin1")
+
+		if !r.isCacheableBuiltin(decl) {
+			impl = uncacheable1(impl)
+		}
+
 		rego.RegisterBuiltin1(decl, impl)
+		r.builtins = append(r.builtins, rego.Function1(decl, impl))
 	}
 
 	for decl, impl := range r.builtins2 {
 		r.Logger.Info().Str("name", decl.Name).Msg("registering builtin2")
+
+		if !r.isCacheableBuiltin(decl) {
+			impl = uncacheable2(impl)
+		}
+
 		rego.RegisterBuiltin2(decl, impl)
+		r.builtins = append(r.builtins, rego.Function2(decl, impl))
 	}
 
 	for decl, impl := range r.builtins3 {
 		r.Logger.Info().Str("name", decl.Name).Msg("registering builtin3")
+
+		if !r.isCacheableBuiltin(decl) {
+			impl = uncacheable3(impl)
+		}
+
 		rego.RegisterBuiltin3(decl, impl)
+		r.builtins = append(r.builtins, rego.Function3(decl, impl))
 	}
 
 	for decl, impl := range r.builtins4 {
 		r.Logger.Info().Str("name", decl.Name).Msg("registering builtin4")
+
+		if !r.isCacheableBuiltin(decl) {
+			impl = uncacheable4(impl)
+		}
+
 		rego.RegisterBuiltin4(decl, impl)
+		r.builtins = append(r.builtins, rego.Function4(decl, impl))
 	}
 
 	for decl, impl := range r.builtinsDyn {
 		r.Logger.Info().Str("name", decl.Name).Msg("registering builtinDyn")
+
+		if !r.isCacheableBuiltin(decl) {
+			impl = uncacheableDyn(impl)
+		}
+
 		rego.RegisterBuiltinDyn(decl, impl)
+		r.builtins = append(r.builtins, rego.FunctionDyn(decl, impl))
 	}
+
+	r.setupPartialEval()
 }
 
 func (r *Runtime) registerDiscovery() error {
@@ -376,7 +494,7 @@ func (r *Runtime) newOPAPluginsManager(ctx context.Context) (*plugins.Manager, e
 	info.Insert(ast.StringTerm("version"), ast.StringTerm(version.Version))
 	info.Insert(ast.StringTerm("commit"), ast.StringTerm(version.Vcs))
 
-	loadedBundles, err := r.loadPaths([]string{})
+	loadedBundles, err := r.loadPaths(ctx, []string{})
 	if err != nil {
 		return nil, errors.Wrap(err, "local bundle load error")
 	}
@@ -393,7 +511,8 @@ func (r *Runtime) newOPAPluginsManager(ctx context.Context) (*plugins.Manager, e
 		plugins.InitBundles(loadedBundles),
 		plugins.Info(ast.NewTerm(info)),
 		plugins.MaxErrors(r.Config.PluginsErrorLimit),
-		plugins.WithParserOptions(ast.ParserOptions{RegoVersion: r.regoVersion}),
+		plugins.WithParserOptions(r.parserOptions()),
+		plugins.EnablePrintStatements(r.printStatements),
 		plugins.GracefulShutdownPeriod(r.Config.GracefulShutdownPeriodSeconds),
 		plugins.Logger(logger.NewOpaLogger(r.Logger)),
 	)
@@ -402,11 +521,17 @@ func (r *Runtime) newOPAPluginsManager(ctx context.Context) (*plugins.Manager, e
 	}
 
 	manager.RegisterPluginStatusListener("aserto-error-recorder", r.pluginStatusCallback)
+	manager.RegisterCompilerTrigger(func(storage.Transaction) {
+		r.onCompilerChange(manager.GetCompiler())
+	})
 
 	if err := manager.Init(ctx); err != nil {
 		return nil, errors.Wrap(err, "initialization error")
 	}
 
+	// without initial bundles, no compiler change is triggered.
+	r.restrictCompiler(manager.GetCompiler())
+
 	// Note: this line is useless because the manager initializes the compiler
 	// during init, and we don't have any control over it.
 	// The compiler creates its own builtins array during its own init(), and
@@ -417,13 +542,20 @@ func (r *Runtime) newOPAPluginsManager(ctx context.Context) (*plugins.Manager, e
 	return manager, nil
 }
 
+func (r *Runtime) parserOptions() ast.ParserOptions {
+	return ast.ParserOptions{RegoVersion: r.regoVersion, ProcessAnnotation: r.annotatedSchemas}
+}
+
 // loadPaths reads data and policy from the given paths and returns a set of bundles
 // if paths is not set, paths will be loaded from cfg.LocalBundles.Paths.
-func (r *Runtime) loadPaths(paths []string) (map[string]*bundle.Bundle, error) {
+func (r *Runtime) loadPaths(ctx context.Context, paths []string) (map[string]*bundle.Bundle, error) {
 	if len(paths) == 0 {
 		paths = r.Config.LocalBundles.Paths
 	}
 
+	_, span := r.startSpan(ctx, "runtime.loadPaths")
+	defer span.End()
+
 	if r.Config.LocalBundles.LocalPolicyImage != "" {
 		tarballpath, err := r.getPolicyTarballPath(r.Config.LocalBundles.LocalPolicyImage)
 		if err != nil {
@@ -435,18 +567,12 @@ func (r *Runtime) loadPaths(paths []string) (map[string]*bundle.Bundle, error) {
 
 	result := make(map[string]*bundle.Bundle, len(paths))
 
-	skipVerify := r.Config.LocalBundles.SkipVerification
-	verificationConfig := r.Config.LocalBundles.VerificationConfig
-
 	var err error
 
 	for _, path := range paths {
 		r.Logger.Info().Str("path", path).Msg("Loading local bundle")
 
-		result[path], err = loader.NewFileLoader().
-			WithBundleVerificationConfig(verificationConfig).
-			WithSkipBundleVerification(skipVerify).
-			AsBundle(path)
+		result[path], err = r.loadBundle(path)
 		if err != nil {
 			errorStatus := bundleplugin.Status{
 				Name: path,
@@ -455,7 +581,7 @@ func (r *Runtime) loadPaths(paths []string) (map[string]*bundle.Bundle, error) {
 
 			r.bundlesStatusCallback(errorStatus)
 
-			return nil, errors.Wrapf(err, "load bundle from local path '%s'", path)
+			return nil, spanError(span, errors.Wrapf(err, "load bundle from local path '%s'", path))
 		}
 
 		r.bundlesStatusCallback(
@@ -471,9 +597,27 @@ func (r *Runtime) loadPaths(paths []string) (map[string]*bundle.Bundle, error) {
 			})
 	}
 
+	if span.IsRecording() {
+		revisions := make([]string, 0, len(result))
+		for _, path := range paths {
+			revisions = append(revisions, path+"="+result[path].Manifest.Revision)
+		}
+
+		span.SetAttributes(attribute.StringSlice(attrPaths, paths), attribute.StringSlice(attrBundleRevisions, revisions))
+	}
+
 	return result, nil
 }
 
+// loadBundle reads a bundle from a local path, verified as configured in cfg.LocalBundles.
+func (r *Runtime) loadBundle(path string) (*bundle.Bundle, error) {
+	return loader.NewFileLoader().
+		WithBundleVerificationConfig(r.Config.LocalBundles.VerificationConfig).
+		WithSkipBundleVerification(r.Config.LocalBundles.SkipVerification).
+		WithProcessAnnotation(r.annotatedSchemas).
+		AsBundle(path)
+}
+
 func (r *Runtime) getPolicyTarballPath(policyImageRef string) (string, error) {
 	storeRoot, err := r.fileStoreRoot()
 	if err != nil {
@@ -32,3 +32,8 @@ func AssetSimpleBundle() string {
 func AssetBuiltinsBundle() string {
 	return filepath.Join(AssetsDir(), "builtin")
 }
+
+// AssetSchemaBundle returns the path of a bundle with a rule annotated with an input schema.
+func AssetSchemaBundle() string {
+	return filepath.Join(AssetsDir(), "schema")
+}
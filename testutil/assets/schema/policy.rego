@@ -0,0 +1,10 @@
+package schema
+
+import rego.v1
+
+# METADATA
+# schemas:
+#   - input: {"type": "object", "properties": {"user": {"type": "string"}}, "required": ["user"]}
+allowed if input.user == "alice"
+
+denied if input.user == "mallory"
@@ -0,0 +1,56 @@
+package runtime
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/open-policy-agent/opa/v1/storage"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/aserto-dev/runtime"
+
+// span attributes.
+const (
+	attrDecisionID      = "runtime.decision_id"
+	attrQuery           = "runtime.query"
+	attrBundleRevisions = "runtime.bundle_revisions"
+	attrResultSize      = "runtime.result_size"
+	attrPath            = "runtime.path"
+	attrPaths           = "runtime.paths"
+	attrTarget          = "runtime.build.target"
+	attrEntrypoints     = "runtime.build.entrypoints"
+)
+
+func (r *Runtime) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return r.tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// spanError records err on span, if any, and returns it.
+func spanError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return err
+}
+
+// setSpanRevisions adds the revisions of the activated bundles, as "name=revision", to a recording span.
+func (r *Runtime) setSpanRevisions(ctx context.Context, span trace.Span, txn storage.Transaction) {
+	if !span.IsRecording() {
+		return
+	}
+
+	revisions := r.bundleRevisions(ctx, txn)
+
+	values := make([]string, 0, len(revisions))
+	for _, name := range slices.Sorted(maps.Keys(revisions)) {
+		values = append(values, name+"="+revisions[name])
+	}
+
+	span.SetAttributes(attribute.StringSlice(attrBundleRevisions, values))
+}
@@ -0,0 +1,66 @@
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+
+	return attrs
+}
+
+func TestTracing(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	}, runtime.WithTracerProvider(tp))
+	assert.NoError(err)
+
+	// Act
+	result, err := r.Query(ctx, "data.simple.allowed", nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	_, compileErr := r.Compile(ctx, "x = undefined_function(1)", nil, nil, nil, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	assert.Error(compileErr)
+
+	spans := recorder.Ended()
+	assert.Len(spans, 3)
+
+	assert.Equal("runtime.loadPaths", spans[0].Name())
+	assert.Equal([]string{testutil.AssetSimpleBundle()}, spanAttributes(spans[0])["runtime.paths"].AsStringSlice())
+
+	query := spanAttributes(spans[1])
+	assert.Equal("runtime.Query", spans[1].Name())
+	assert.Equal(result.DecisionID, query["runtime.decision_id"].AsString())
+	assert.Equal("data.simple.allowed", query["runtime.query"].AsString())
+	assert.Equal(int64(1), query["runtime.result_size"].AsInt64())
+	assert.Len(query["runtime.bundle_revisions"].AsStringSlice(), 1)
+	assert.Equal(codes.Unset, spans[1].Status().Code)
+
+	assert.Equal("runtime.Compile", spans[2].Name())
+	assert.Equal(codes.Error, spans[2].Status().Code)
+	assert.Len(spans[2].Events(), 1)
+}
@@ -0,0 +1,115 @@
+package runtime
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+)
+
+// DefaultUnsafeBuiltins lists the builtins that are forbidden when no unsafe builtins are configured.
+var DefaultUnsafeBuiltins = []string{ast.HTTPSend.Name}
+
+// BuiltinsConfig controls which builtin functions policies are allowed to use.
+type BuiltinsConfig struct {
+	// Unsafe lists the builtins that policies and queries are not allowed to call.
+	// If nil, DefaultUnsafeBuiltins are forbidden. Use an empty list to allow all builtins.
+	Unsafe []string `json:"unsafe"`
+	// HTTPSendAllowedHosts restricts the hosts http.send can reach, when it is not listed as unsafe.
+	// If empty, http.send can reach any host.
+	HTTPSendAllowedHosts []string `json:"http_send_allowed_hosts"`
+}
+
+func (c *BuiltinsConfig) unsafeBuiltins() []string {
+	if c.Unsafe == nil {
+		return DefaultUnsafeBuiltins
+	}
+
+	return c.Unsafe
+}
+
+func (r *Runtime) setupUnsafeBuiltins() {
+	if r.unsafeBuiltins == nil {
+		r.unsafeBuiltins = map[string]struct{}{}
+
+		for _, name := range r.Config.Builtins.unsafeBuiltins() {
+			r.unsafeBuiltins[name] = struct{}{}
+		}
+	}
+
+	if r.httpSendAllowedHosts == nil && len(r.Config.Builtins.HTTPSendAllowedHosts) > 0 {
+		r.httpSendAllowedHosts = map[string]struct{}{}
+
+		for _, host := range r.Config.Builtins.HTTPSendAllowedHosts {
+			r.httpSendAllowedHosts[host] = struct{}{}
+		}
+	}
+
+	if len(r.httpSendAllowedHosts) > 0 {
+		r.capabilities = ast.CapabilitiesForThisVersion()
+		r.capabilities.AllowNet = slices.Sorted(maps.Keys(r.httpSendAllowedHosts))
+	}
+
+	if len(r.unsafeBuiltins) > 0 {
+		r.storage = newPolicyGuard(r.storage, r.parserOptions(), r.checkPolicies)
+	}
+}
+
+// restrictCompiler sets the http.send host allowlist on the capabilities of a compiler installed by the plugins manager.
+// The bundle and discovery plugins compile policies with compilers the runtime cannot configure, so this runs within
+// the commit of the store transaction, before any query can use the compiler.
+func (r *Runtime) restrictCompiler(compiler *ast.Compiler) {
+	if r.capabilities == nil {
+		return
+	}
+
+	caps := *r.capabilities
+	if current := compiler.Capabilities(); current != nil {
+		caps = *current
+		caps.AllowNet = r.capabilities.AllowNet
+	}
+
+	compiler.WithCapabilities(&caps)
+}
+
+// checkPolicies returns an error if modules call unsafe builtins, see policyGuard.
+func (r *Runtime) checkPolicies(modules map[string]*ast.Module) error {
+	var errs ast.Errors
+
+	for _, id := range slices.Sorted(maps.Keys(modules)) {
+		errs = append(errs, unsafeBuiltinCalls(r.unsafeBuiltins, modules[id])...)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	r.Logger.Error().Err(errs).Msg("policies call unsafe builtins, activation rejected")
+
+	return errs
+}
+
+func unsafeBuiltinCalls(unsafe map[string]struct{}, module *ast.Module) ast.Errors {
+	var errs ast.Errors
+
+	check := func(name string, loc *ast.Location) {
+		if _, ok := unsafe[name]; ok {
+			errs = append(errs, ast.NewError(ast.TypeErr, loc, "unsafe built-in function calls in expression: %v", name))
+		}
+	}
+
+	ast.NewGenericVisitor(func(x any) bool {
+		switch x := x.(type) {
+		case *ast.Expr:
+			if x.IsCall() {
+				check(x.Operator().String(), x.Location)
+			}
+		case ast.Call:
+			check(x[0].String(), x[0].Location)
+		}
+
+		return false
+	}).Walk(module)
+
+	return errs
+}
@@ -0,0 +1,154 @@
+package runtime_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/download"
+	"github.com/open-policy-agent/opa/v1/plugins"
+	bundleplugin "github.com/open-policy-agent/opa/v1/plugins/bundle"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnsafeBuiltins(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		Builtins: runtime.BuiltinsConfig{
+			Unsafe: []string{"http.send", "opa.runtime"},
+		},
+	})
+	assert.NoError(err)
+
+	// Act
+	_, queryErr := r.Query(ctx, "x = opa.runtime()", nil, false, false, false, types.ExplainOffV1)
+	_, safeErr := r.Query(ctx, "x = time.now_ns()", nil, false, false, false, types.ExplainOffV1)
+	upsertErr := storage.Txn(ctx, r.GetPluginsManager().Store, storage.WriteParams, func(txn storage.Transaction) error {
+		return r.GetPluginsManager().Store.UpsertPolicy(ctx, txn, "unsafe.rego", []byte(`package unsafe
+
+rt := object.get(opa.runtime(), "version", "")
+`))
+	})
+	policies, policiesErr := r.ListPolicies(ctx)
+
+	// Assert
+	assert.ErrorContains(queryErr, "unsafe built-in function calls in expression: opa.runtime")
+	assert.NoError(safeErr)
+	assert.ErrorContains(upsertErr, "unsafe built-in function calls in expression: opa.runtime")
+	assert.NoError(policiesErr)
+	assert.Empty(policies)
+}
+
+func bundleTarball(t *testing.T, revision, policy string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	err := bundle.NewWriter(&buf).Write(bundle.Bundle{
+		Manifest: bundle.Manifest{Revision: revision, Roots: &[]string{"guarded"}},
+		Modules:  []bundle.ModuleFile{{URL: "/guarded/policy.rego", Path: "/guarded/policy.rego", Raw: []byte(policy)}},
+		Data:     map[string]any{},
+	})
+	require.NoError(t, err)
+
+	return buf.Bytes()
+}
+
+func TestUnsafeBuiltinsBundleActivation(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	var served atomic.Pointer[[]byte]
+
+	safe := bundleTarball(t, "safe", "package guarded\n\nallowed := true\n")
+	served.Store(&safe)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write(*served.Load())
+	}))
+	t.Cleanup(srv.Close)
+
+	trigger := plugins.TriggerManual
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		Config: runtime.OPAConfig{
+			Services: map[string]any{"bundles": map[string]any{"url": srv.URL}},
+			Bundles: map[string]*bundleplugin.Source{
+				"guarded": {Config: download.Config{Trigger: &trigger}, Service: "bundles", Resource: "bundle.tar.gz"},
+			},
+		},
+		Builtins: runtime.BuiltinsConfig{Unsafe: []string{"opa.runtime"}},
+	})
+	assert.NoError(err)
+
+	assert.NoError(r.Start(ctx))
+	t.Cleanup(func() { r.Stop(ctx) })
+
+	assert.NoError(bundleplugin.Lookup(r.GetPluginsManager()).Trigger(ctx))
+
+	unsafe := bundleTarball(t, "unsafe", "package guarded\n\nallowed := object.get(opa.runtime(), \"version\", \"\") != \"\"\n")
+	served.Store(&unsafe)
+
+	// Act
+	triggerErr := bundleplugin.Lookup(r.GetPluginsManager()).Trigger(ctx)
+	result, queryErr := r.Query(ctx, "x = data.guarded.allowed", nil, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	assert.ErrorContains(triggerErr, "unsafe built-in function calls in expression: opa.runtime")
+	assert.NoError(queryErr)
+	assert.Len(result.Result, 1)
+	assert.Equal(true, result.Result[0].Bindings["x"])
+
+	bundles := r.Status().Bundles
+	assert.Len(bundles, 1)
+	assert.Equal("safe", bundles[0].Revision)
+	assert.NotEmpty(bundles[0].Errors)
+}
+
+func TestHTTPSendAllowedHosts(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok": true}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	srvURL, err := url.Parse(srv.URL)
+	assert.NoError(err)
+
+	query := `resp := http.send({"method": "get", "url": "` + srv.URL + `", "raise_error": true})`
+
+	allowed, err := runtime.New(ctx, &runtime.Config{
+		Builtins: runtime.BuiltinsConfig{Unsafe: []string{}},
+	}, runtime.WithHTTPSendAllowedHosts(srvURL.Hostname()))
+	assert.NoError(err)
+
+	denied, err := runtime.New(ctx, &runtime.Config{
+		Builtins: runtime.BuiltinsConfig{Unsafe: []string{}, HTTPSendAllowedHosts: []string{"example.com"}},
+	})
+	assert.NoError(err)
+
+	// Act
+	allowedResult, allowedErr := allowed.Query(ctx, query, nil, false, false, false, types.ExplainOffV1)
+	deniedResult, deniedErr := denied.Query(ctx, query, nil, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	assert.NoError(allowedErr)
+	assert.Len(allowedResult.Result, 1)
+	// builtin errors make the expression undefined.
+	assert.NoError(deniedErr)
+	assert.Empty(deniedResult.Result)
+}
@@ -0,0 +1,56 @@
+package runtime
+
+import (
+	"slices"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/pkg/errors"
+)
+
+// wasmEngineAvailable is set when the runtime is built with the opa_wasm tag, which links the wasm engine.
+var wasmEngineAvailable bool
+
+// WasmEntrypoints returns the entrypoints compiled to wasm in the activated bundles, e.g. "data.example.allowed".
+func (r *Runtime) WasmEntrypoints() []string {
+	entrypoints := []string{}
+
+	for _, resolver := range r.pluginsManager.GetWasmResolvers() {
+		for _, ref := range resolver.Entrypoints() {
+			entrypoints = append(entrypoints, ref.String())
+		}
+	}
+
+	slices.Sort(entrypoints)
+
+	return entrypoints
+}
+
+// internalQuery marks a query made by the runtime itself, e.g. to list the activated bundles.
+// Internal queries are evaluated by topdown in wasm mode, as system documents are not compiled to wasm,
+// and are not logged as decisions.
+func internalQuery() QueryOption {
+	return func(o *queryOptions) {
+		o.internal = true
+	}
+}
+
+// checkWasmQuery returns an error if the runtime evaluates queries with the wasm engine, see WithWasmEvaluation,
+// and parsedQuery is neither a compiled entrypoint, optionally assigned to a variable, nor an internal query.
+func (r *Runtime) checkWasmQuery(parsedQuery ast.Body, qo *queryOptions) error {
+	if !r.wasmEvaluation || qo.internal {
+		return nil
+	}
+
+	ref, ok := queryEntrypoint(parsedQuery)
+	if ok {
+		for _, resolver := range r.pluginsManager.GetWasmResolvers() {
+			for _, entrypoint := range resolver.Entrypoints() {
+				if entrypoint.Equal(ref) {
+					return nil
+				}
+			}
+		}
+	}
+
+	return errors.Wrapf(ErrNotWasmEntrypoint, "query [%s]", parsedQuery)
+}
@@ -0,0 +1,21 @@
+//go:build !opa_wasm
+
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWasmEvaluationRequiresEngine(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+
+	// Act
+	_, err := runtime.New(t.Context(), &runtime.Config{}, runtime.WithWasmEvaluation())
+
+	// Assert
+	assert.ErrorContains(err, "opa_wasm")
+}
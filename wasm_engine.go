@@ -0,0 +1,12 @@
+//go:build opa_wasm
+
+package runtime
+
+import (
+	// registers the wasm engine used to evaluate the entrypoints of wasm bundles.
+	_ "github.com/open-policy-agent/opa/v1/features/wasm"
+)
+
+func init() {
+	wasmEngineAvailable = true
+}
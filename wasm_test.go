@@ -0,0 +1,120 @@
+//go:build opa_wasm
+
+package runtime_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	opatypes "github.com/open-policy-agent/opa/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+const wasmPolicy = `package wasmtest
+
+import rego.v1
+
+greeting := test.wasm_greet(input.name)
+
+allowed if input.name == "alice"
+`
+
+func wasmGreetBuiltin() runtime.Option {
+	return runtime.WithBuiltin1(&rego.Function{
+		Name: "test.wasm_greet",
+		Decl: opatypes.NewFunction(opatypes.Args(opatypes.S), opatypes.S),
+	}, func(_ rego.BuiltinContext, name *ast.Term) (*ast.Term, error) {
+		return ast.StringTerm("hello " + string(name.Value.(ast.String))), nil
+	})
+}
+
+func TestWasmEvaluation(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(dir, "policy.rego"), []byte(wasmPolicy), 0o600))
+
+	builder, err := runtime.New(ctx, &runtime.Config{}, wasmGreetBuiltin())
+	assert.NoError(err)
+
+	out := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	assert.NoError(builder.Build(&runtime.BuildParams{
+		Target:      runtime.Wasm,
+		Entrypoints: []string{"wasmtest/greeting", "wasmtest/allowed"},
+		OutputFile:  out,
+		RegoVersion: runtime.RegoV1,
+	}, []string{dir}))
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{out},
+		},
+	}, wasmGreetBuiltin(), runtime.WithWasmEvaluation())
+	assert.NoError(err)
+
+	input := map[string]any{"name": "alice"}
+
+	// Act
+	greeting, err := r.Query(ctx, "data.wasmtest.greeting", input, false, true, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	allowed, err := r.Query(ctx, "x = data.wasmtest.allowed", input, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	_, packageErr := r.Query(ctx, "data.wasmtest", input, false, false, false, types.ExplainOffV1)
+	_, exprErr := r.Query(ctx, "x = 1", nil, false, false, false, types.ExplainOffV1)
+	_, compileErr := r.Compile(ctx, "data.wasmtest.allowed", nil, []string{"input"}, nil, false, false, false, types.ExplainOffV1)
+
+	// Assert
+	assert.Equal([]string{"data.wasmtest.allowed", "data.wasmtest.greeting"}, r.WasmEntrypoints())
+
+	assert.Equal("hello alice", greeting.Result[0].Expressions[0].Value)
+	// the entrypoint is executed by the wasm engine.
+	assert.Contains(greeting.Metrics, "timer_wasm_vm_eval_ns")
+	assert.Equal(true, allowed.Result[0].Bindings["x"])
+
+	assert.ErrorIs(packageErr, runtime.ErrNotWasmEntrypoint)
+	assert.ErrorIs(exprErr, runtime.ErrNotWasmEntrypoint)
+	assert.ErrorIs(compileErr, runtime.ErrWasmUnsupported)
+}
+
+func TestWasmEvaluationGetBundles(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+	dir := t.TempDir()
+
+	assert.NoError(os.WriteFile(filepath.Join(dir, "policy.rego"), []byte(wasmPolicy), 0o600))
+
+	builder, err := runtime.New(ctx, &runtime.Config{}, wasmGreetBuiltin())
+	assert.NoError(err)
+
+	out := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	assert.NoError(builder.Build(&runtime.BuildParams{
+		Target:      runtime.Wasm,
+		Entrypoints: []string{"wasmtest/allowed"},
+		OutputFile:  out,
+		RegoVersion: runtime.RegoV1,
+	}, []string{dir}))
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{out},
+		},
+	}, wasmGreetBuiltin(), runtime.WithWasmEvaluation())
+	assert.NoError(err)
+
+	// Act
+	bundles, err := r.GetBundles(ctx)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(bundles, 1)
+}
@@ -0,0 +1,164 @@
+package runtime
+
+import (
+	"context"
+	"maps"
+	"slices"
+
+	"github.com/open-policy-agent/opa/v1/ast"
+	"github.com/open-policy-agent/opa/v1/metrics"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/open-policy-agent/opa/v1/storage"
+	"github.com/pkg/errors"
+)
+
+// DataPatch is a JSON patch style change to the data document.
+type DataPatch struct {
+	// Op is one of "add", "remove" or "replace".
+	Op string `json:"op"`
+	// Path is a JSON pointer into the data document, e.g. "/groups/admins/members/0".
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// WhatIf describes the hypothetical changes a QueryWhatIf call is evaluated against.
+type WhatIf struct {
+	// Patches are applied to the data document, in order.
+	Patches []DataPatch
+	// Modules add or replace policies, keyed by policy ID.
+	Modules map[string]string
+}
+
+// PatchResult reports whether a DataPatch was applied.
+type PatchResult struct {
+	DataPatch
+
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+// WhatIfResult contains the results of a QueryWhatIf execution.
+type WhatIfResult struct {
+	*Result
+
+	// Patches holds the outcome of each patch, in the order they were given.
+	Patches []PatchResult
+	// Modules lists the IDs of the added or replaced policies.
+	Modules []string
+}
+
+// QueryWhatIf evaluates a query as if the data patches and modules of whatIf were in effect.
+// The changes are made inside a write transaction that is always aborted, so the runtime's
+// policies and data are never modified. Patches that cannot be applied are reported in the
+// result and skipped, while modules that fail to compile fail the query. A nil whatIf has no changes.
+// What-if decisions are not sent to the decision logs.
+func (r *Runtime) QueryWhatIf(
+	ctx context.Context,
+	qStr string,
+	input map[string]any,
+	whatIf *WhatIf,
+	opts ...QueryOption,
+) (*WhatIfResult, error) {
+	if whatIf == nil {
+		whatIf = &WhatIf{}
+	}
+
+	qo := r.queryOptions(opts)
+	qo.modules = whatIf.Modules
+	qo.noDecisionCache = true
+	ctx, decisionID := r.decisionContext(ctx, qo)
+
+	parsedQuery, err := r.ValidateQuery(qStr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to validate query")
+	}
+
+	release, err := r.admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defer release()
+
+	txn, err := r.storage.NewTransaction(ctx, storage.WriteParams)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create new OPA store transaction")
+	}
+
+	defer r.storage.Abort(ctx, txn)
+
+	patches := r.applyDataPatches(ctx, txn, whatIf.Patches)
+
+	result, err := r.evalQuery(ctx, txn, decisionID, parsedQuery, input, metrics.New(), types.ExplainOffV1, false, false, false, qo)
+	if err != nil {
+		return nil, evalError(decisionID, qStr, err)
+	}
+
+	return &WhatIfResult{
+		Result:  result,
+		Patches: patches,
+		Modules: slices.Sorted(maps.Keys(whatIf.Modules)),
+	}, nil
+}
+
+func (r *Runtime) applyDataPatches(ctx context.Context, txn storage.Transaction, patches []DataPatch) []PatchResult {
+	results := make([]PatchResult, len(patches))
+
+	for i, patch := range patches {
+		results[i].DataPatch = patch
+
+		if err := r.applyDataPatch(ctx, txn, patch); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		results[i].Applied = true
+	}
+
+	return results
+}
+
+func (r *Runtime) applyDataPatch(ctx context.Context, txn storage.Transaction, patch DataPatch) error {
+	var op storage.PatchOp
+
+	switch patch.Op {
+	case "add":
+		op = storage.AddOp
+	case "remove":
+		op = storage.RemoveOp
+	case "replace":
+		op = storage.ReplaceOp
+	default:
+		return errors.Errorf("invalid patch op [%s]", patch.Op)
+	}
+
+	path, ok := storage.ParsePathEscaped(patch.Path)
+	if !ok {
+		return errors.Errorf("invalid patch path [%s]", patch.Path)
+	}
+
+	return r.storage.Write(ctx, txn, op, path, patch.Value)
+}
+
+// prepareQuery prepares a query against the runtime's compiler or, for what-if queries
+// with modules, against a compiler built from the stored policies and those modules.
+func (r *Runtime) prepareQuery(
+	ctx context.Context,
+	txn storage.Transaction,
+	parsedQuery ast.Body,
+	m metrics.Metrics,
+	qo *queryOptions,
+) (rego.PreparedEvalQuery, error) {
+	if len(qo.modules) == 0 {
+		return r.preparedQuery(ctx, txn, parsedQuery, m)
+	}
+
+	opts := append(r.regoOptions(txn, parsedQuery, m), rego.SetRegoVersion(r.regoVersion))
+
+	for id, module := range qo.modules {
+		opts = append(opts, rego.Module(id, module))
+	}
+
+	return rego.New(opts...).PrepareForEval(ctx)
+}
@@ -0,0 +1,83 @@
+package runtime_test
+
+import (
+	"testing"
+
+	runtime "github.com/aserto-dev/runtime"
+	"github.com/aserto-dev/runtime/testutil"
+	"github.com/open-policy-agent/opa/v1/server/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryWhatIf(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	policies, err := r.ListPolicies(ctx)
+	assert.NoError(err)
+	assert.Len(policies, 1)
+
+	whatIf := &runtime.WhatIf{
+		Patches: []runtime.DataPatch{
+			{Op: "add", Path: "/groups", Value: map[string]any{"admins": []any{"alice", "bob"}}},
+			{Op: "remove", Path: "/groups/admins/0"},
+			{Op: "remove", Path: "/users/alice"},
+		},
+		Modules: map[string]string{
+			policies[0].ID: "package simple\n\nimport rego.v1\n\nallowed if \"bob\" in data.groups.admins\n",
+		},
+	}
+
+	// Act
+	result, err := r.QueryWhatIf(ctx, "x = data.simple.allowed; y = data.groups.admins", nil, whatIf)
+	assert.NoError(err)
+
+	actual, err := r.Query(ctx, "x = data.simple.allowed; y = data.groups", nil, false, false, false, types.ExplainOffV1)
+	assert.NoError(err)
+
+	// Assert
+	assert.Len(result.Result.Result, 1)
+	assert.Equal(true, result.Result.Result[0].Bindings["x"])
+	assert.Equal([]any{"bob"}, result.Result.Result[0].Bindings["y"])
+
+	assert.Len(result.Patches, 3)
+	assert.True(result.Patches[0].Applied)
+	assert.True(result.Patches[1].Applied)
+	assert.False(result.Patches[2].Applied)
+	assert.NotEmpty(result.Patches[2].Error)
+	assert.Equal([]string{policies[0].ID}, result.Modules)
+
+	// the real policies and data are unchanged.
+	assert.Empty(actual.Result)
+}
+
+func TestQueryWhatIfNil(t *testing.T) {
+	// Arrange
+	assert := require.New(t)
+	ctx := t.Context()
+
+	r, err := runtime.New(ctx, &runtime.Config{
+		LocalBundles: runtime.LocalBundlesConfig{
+			Paths: []string{testutil.AssetSimpleBundle()},
+		},
+	})
+	assert.NoError(err)
+
+	// Act
+	result, err := r.QueryWhatIf(ctx, "x = data.simple.allowed", nil, nil)
+
+	// Assert
+	assert.NoError(err)
+	assert.Len(result.Result.Result, 1)
+	assert.Equal(false, result.Result.Result[0].Bindings["x"])
+	assert.Empty(result.Patches)
+	assert.Empty(result.Modules)
+}